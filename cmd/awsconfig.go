@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	awsProfileFlag     = "aws-profile"
+	awsRegionFlag      = "aws-region"
+	roleARNFlag        = "assume-role-arn"
+	roleExternalIDFlag = "assume-role-external-id"
+	roleSessionFlag    = "assume-role-session-name"
+	kmsProfileFlag     = "kms-aws-profile"
+	kmsRegionFlag      = "kms-aws-region"
+	kmsRoleARNFlag     = "kms-assume-role-arn"
+	kmsExternalIDFlag  = "kms-assume-role-external-id"
+)
+
+// addAWSFlags adds the flags choosing AWS credentials to every command
+func addAWSFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.String(awsProfileFlag, "", "AWS shared config profile (default $AWS_PROFILE)")
+	flags.String(awsRegionFlag, "", "AWS region (default $AWS_REGION)")
+	flags.String(roleARNFlag, "", "IAM role to assume for S3 and KMS, such as a role in the backup account")
+	flags.String(roleExternalIDFlag, "", "external ID required by the role given with --"+roleARNFlag)
+	flags.String(roleSessionFlag, aws.DefaultSessionName, "session name of assumed roles, shown in CloudTrail")
+	flags.String(kmsProfileFlag, "", "AWS shared config profile for KMS, when it differs from S3")
+	flags.String(kmsRegionFlag, "", "AWS region for KMS key IDs that are not ARNs, when it differs from S3")
+	flags.String(kmsRoleARNFlag, "", "IAM role to assume for KMS, when it differs from S3")
+	flags.String(kmsExternalIDFlag, "", "external ID required by the role given with --"+kmsRoleARNFlag)
+
+	for _, name := range []string{awsProfileFlag, awsRegionFlag, roleARNFlag, roleExternalIDFlag, roleSessionFlag, kmsProfileFlag, kmsRegionFlag, kmsRoleARNFlag, kmsExternalIDFlag} {
+		viper.BindPFlag(name, flags.Lookup(name))
+	}
+}
+
+// configureAWS sets up S3 and KMS clients from the flags, KMS uses the S3
+// settings unless a KMS profile, role or region is given
+func configureAWS() {
+	s3cfg := aws.EnvConfig()
+	if region := viper.GetString(awsRegionFlag); region != "" {
+		s3cfg.Region = region
+	}
+	s3cfg.Profile = viper.GetString(awsProfileFlag)
+	s3cfg.AssumeRoleARN = viper.GetString(roleARNFlag)
+	s3cfg.ExternalID = viper.GetString(roleExternalIDFlag)
+	s3cfg.SessionName = viper.GetString(roleSessionFlag)
+
+	kmsProfile := viper.GetString(kmsProfileFlag)
+	kmsRegion := viper.GetString(kmsRegionFlag)
+	kmsRole := viper.GetString(kmsRoleARNFlag)
+	if kmsProfile == "" && kmsRegion == "" && kmsRole == "" {
+		aws.Configure(s3cfg, nil)
+		return
+	}
+
+	kmscfg := *s3cfg
+	if kmsRegion != "" {
+		kmscfg.Region = kmsRegion
+	}
+	if kmsProfile != "" {
+		// credentials of another profile, the S3 role is not assumed from them
+		kmscfg.Profile = kmsProfile
+		kmscfg.AssumeRoleARN, kmscfg.ExternalID = "", ""
+	}
+	if kmsRole != "" {
+		kmscfg.AssumeRoleARN = kmsRole
+		kmscfg.ExternalID = viper.GetString(kmsExternalIDFlag)
+	}
+	aws.Configure(s3cfg, &kmscfg)
+}
@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/vault"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -30,11 +33,39 @@ func exitErr(e error) {
 	os.Exit(1)
 }
 
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createOutput opens path for writing with UMASK permissions, or returns
+// stdout when no path is given
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+
+	ff, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ff.Chmod(UMASK); err != nil {
+		ff.Close()
+		return nil, err
+	}
+	return ff, nil
+}
+
 func init() {
 	rootCmd = &cobra.Command{
 		Use: "vault-tools <subcommand> [flags]",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			configureAWS()
+		},
 	}
 	rootCmd.Version = version
+	bundle.ToolVersion = version
 
 	logSetup()
 	cobra.OnInitialize(initConfig)
@@ -45,11 +76,29 @@ func init() {
 	rootCmd.PersistentFlags().StringSlice(ignoreKeysFlag, []string{}, "comma separated list of key names to ignore")
 	rootCmd.PersistentFlags().StringSlice(ignorePathsFlag, []string{}, "comma separated list of paths to ignore")
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().String(identityFlag, "", "X25519 identity file used to decrypt bundles")
+	rootCmd.PersistentFlags().StringSlice(sharesFlag, []string{}, "shamir share files, or shares, used to decrypt bundles, may be repeated")
+	rootCmd.PersistentFlags().String(manifestHMACKeyFlag, "", "HMAC key used to sign and verify backup manifests")
+	rootCmd.PersistentFlags().String(manifestKMSKeyFlag, "", "asymmetric KMS key ARN used to sign and verify backup manifests")
+	rootCmd.PersistentFlags().String(passphraseFileFlag, "", "file holding the bundle passphrase (default $VAULT_DUMP_PASSPHRASE)")
+	rootCmd.PersistentFlags().String(transitAddrFlag, "", "url of the vault holding the transit key")
+	rootCmd.PersistentFlags().String(transitTokenFlag, "", "token for the transit vault")
+	rootCmd.PersistentFlags().String(transitMountFlag, vault.DefaultTransitMount, "mount path of the transit secrets engine")
+
+	addAWSFlags(rootCmd)
 
 	viper.BindPFlag(ignorePathsFlag, rootCmd.PersistentFlags().Lookup(ignorePathsFlag))
 	viper.BindPFlag(ignoreKeysFlag, rootCmd.PersistentFlags().Lookup(ignoreKeysFlag))
 	viper.BindPFlag(vaFlag, rootCmd.PersistentFlags().Lookup(vaFlag))
 	viper.BindPFlag(vtFlag, rootCmd.PersistentFlags().Lookup(vtFlag))
+	viper.BindPFlag(identityFlag, rootCmd.PersistentFlags().Lookup(identityFlag))
+	viper.BindPFlag(sharesFlag, rootCmd.PersistentFlags().Lookup(sharesFlag))
+	viper.BindPFlag(manifestHMACKeyFlag, rootCmd.PersistentFlags().Lookup(manifestHMACKeyFlag))
+	viper.BindPFlag(manifestKMSKeyFlag, rootCmd.PersistentFlags().Lookup(manifestKMSKeyFlag))
+	viper.BindPFlag(passphraseFileFlag, rootCmd.PersistentFlags().Lookup(passphraseFileFlag))
+	viper.BindPFlag(transitAddrFlag, rootCmd.PersistentFlags().Lookup(transitAddrFlag))
+	viper.BindPFlag(transitTokenFlag, rootCmd.PersistentFlags().Lookup(transitTokenFlag))
+	viper.BindPFlag(transitMountFlag, rootCmd.PersistentFlags().Lookup(transitMountFlag))
 }
 
 func initConfig() {
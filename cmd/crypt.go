@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+const (
+	encryptWithFlag    = "encrypt-with"
+	identityFlag       = "identity"
+	kdfFlag            = "kdf"
+	kmsContextFlag     = "kms-context"
+	passphraseFileFlag = "passphrase-file"
+	passphraseKey      = "passphrase" // VAULT_DUMP_PASSPHRASE
+	recipientFlag      = "recipient"
+	sharesFlag         = "shares"
+	sharesDirFlag      = "shares-dir"
+	splitFlag          = "split"
+	thresholdFlag      = "threshold"
+	transitAddrFlag    = "transit-addr"
+	transitKeyFlag     = "transit-key"
+	transitMountFlag   = "transit-mount"
+	transitTokenFlag   = "transit-token"
+)
+
+var providers = []string{bundle.KMS, bundle.X25519, bundle.Passphrase, bundle.Transit, bundle.Shamir}
+
+// cryptOptions select the provider and recipients a bundle is encrypted for
+type cryptOptions struct {
+	Provider    string
+	KMSKeys     []string
+	Recipients  []string
+	TransitKey  string
+	KDF         string
+	Compression string
+	// Split and Threshold configure shamir, its shares are written to
+	// SharesDir or printed when it is empty
+	Split     int
+	Threshold int
+	SharesDir string
+	// Context holds KMS encryption context pairs in addition to the
+	// application name
+	Context map[string]string
+}
+
+// validate checks the options without contacting any provider
+func (o *cryptOptions) validate() error {
+	switch o.Provider {
+	case "", bundle.KMS:
+		if len(o.KMSKeys) == 0 {
+			return errors.New("error: KMS key ARN must be specified")
+		}
+	case bundle.X25519:
+		if len(o.Recipients) == 0 {
+			return fmt.Errorf("error: --%s requires at least one --%s", encryptWithFlag, recipientFlag)
+		}
+	case bundle.Passphrase:
+	case bundle.Transit:
+		if o.TransitKey == "" {
+			return fmt.Errorf("error: --%s %s requires --%s", encryptWithFlag, bundle.Transit, transitKeyFlag)
+		}
+	case bundle.Shamir:
+		if o.Threshold < 2 || o.Split < o.Threshold || o.Split > 255 {
+			return fmt.Errorf("error: --%s %s requires --%s of at least 2 and --%s of at least the threshold, at most 255", encryptWithFlag, bundle.Shamir, thresholdFlag, splitFlag)
+		}
+	default:
+		return fmt.Errorf("error: unsupported provider %q, we only accept: %v", o.Provider, providers)
+	}
+	return nil
+}
+
+// withContext returns a copy of o with the encryption context pair key=value
+// added, unless one was given on the command line
+func (o *cryptOptions) withContext(key string, value string) *cryptOptions {
+	oo := *o
+	oo.Context = map[string]string{key: value}
+	for kk, vv := range o.Context {
+		oo.Context[kk] = vv
+	}
+	return &oo
+}
+
+// recipients builds the providers the data key is wrapped for, and the
+// encryption context they bind it to
+func (o *cryptOptions) recipients() ([]bundle.Provider, map[string]string, error) {
+	if err := o.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	switch o.Provider {
+	case bundle.X25519:
+		recipients := make([]bundle.Provider, 0, len(o.Recipients))
+		for _, rr := range o.Recipients {
+			pp, err := bundle.NewX25519Recipient(rr)
+			if err != nil {
+				return nil, nil, err
+			}
+			recipients = append(recipients, pp)
+		}
+		return recipients, nil, nil
+	case bundle.Passphrase:
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		pp, err := bundle.NewPassphrase(passphrase, o.KDF)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []bundle.Provider{pp}, nil, nil
+	case bundle.Transit:
+		tp, err := transitProvider(o.TransitKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []bundle.Provider{tp}, nil, nil
+	case bundle.Shamir:
+		split, err := bundle.NewShamirSplit(o.Split, o.Threshold)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := o.writeShares(split); err != nil {
+			return nil, nil, err
+		}
+		return []bundle.Provider{split}, nil, nil
+	}
+
+	context, err := aws.KMSEncryptionContext(o.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aws.KMSProviders(o.KMSKeys, context), context, nil
+}
+
+// writeShares saves each share of split to its own file in SharesDir, named
+// after the file being encrypted, or prints them when no directory is given
+func (o *cryptOptions) writeShares(split *bundle.ShamirProvider) error {
+	shares := split.Shares()
+	if o.SharesDir == "" {
+		fmt.Fprintf(os.Stderr, "Shares of set %s, %d of %d are needed to decrypt:\n", split.SetID(), o.Threshold, len(shares))
+		for _, share := range shares {
+			fmt.Fprintln(os.Stderr, share)
+		}
+		return nil
+	}
+
+	name := o.Context["filename"]
+	if name == "" {
+		name = "vault-dump"
+	}
+	if err := os.MkdirAll(o.SharesDir, 0700); err != nil {
+		return err
+	}
+	for ii, share := range shares {
+		path := filepath.Join(o.SharesDir, fmt.Sprintf("%s.share-%d-of-%d.txt", name, ii+1, len(shares)))
+		ff, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(ff, share); err != nil {
+			ff.Close()
+			return err
+		}
+		if err := ff.Close(); err != nil {
+			return err
+		}
+		log.Printf("Wrote share %d of %d to %s", ii+1, len(shares), path)
+	}
+	return nil
+}
+
+// encryptWriter returns a writer compressing and encrypting into w for the
+// recipients selected by opts, Close seals the bundle but does not close w
+func encryptWriter(w io.Writer, opts *cryptOptions) (io.WriteCloser, error) {
+	recipients, context, err := opts.recipients()
+	if err != nil {
+		return nil, err
+	}
+	return bundle.NewWriter(w, &bundle.Options{
+		Recipients:  recipients,
+		Compression: opts.Compression,
+		Context:     context,
+	})
+}
+
+// decryptReader returns a reader over the plaintext of the bundle read from
+// r, the provider is detected from the bundle header and the recipient
+// used is logged
+func decryptReader(r io.Reader) (io.Reader, error) {
+	plaintext, err := bundle.NewReader(r, resolveProvider)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Decrypted with %s", plaintext)
+	return plaintext, nil
+}
+
+// resolveProvider returns the provider for a stanza using the identity file
+// and passphrase configured on the command line or environment
+func resolveProvider(stanza *bundle.Stanza) (bundle.Provider, error) {
+	switch stanza.Provider {
+	case bundle.X25519:
+		return readIdentities()
+	case bundle.Passphrase:
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		return bundle.NewPassphrase(passphrase, "")
+	case bundle.Transit:
+		// the key named by the bundle is accepted unless one is configured
+		return transitProvider(viper.GetString(transitKeyFlag))
+	case bundle.Shamir:
+		return readShares()
+	}
+	return aws.KMSResolver(stanza)
+}
+
+// transitProvider connects to the Vault holding the transit key, which is
+// configured separately from the Vault being dumped or imported
+func transitProvider(key string) (bundle.Provider, error) {
+	addr := viper.GetString(transitAddrFlag)
+	if addr == "" {
+		return nil, fmt.Errorf("error: transit Vault address must be specified with --%s", transitAddrFlag)
+	}
+	vc, err := vault.NewClient(&vault.Config{
+		Address: addr,
+		Token:   viper.GetString(transitTokenFlag),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vault.NewTransitProvider(vc, viper.GetString(transitMountFlag), key), nil
+}
+
+// readIdentities returns the identities in the --identity file, unwrapping
+// picks the one matching the recipient recorded in the stanza
+func readIdentities() (bundle.Provider, error) {
+	path := viper.GetString(identityFlag)
+	if path == "" {
+		return nil, fmt.Errorf("no identity file given with --%s", identityFlag)
+	}
+	ff, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+
+	identities, err := bundle.ReadX25519Identities(ff)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return identityList(identities), nil
+}
+
+// readShares combines the shares given with --shares, each entry is either
+// a file holding a share or the share itself
+func readShares() (bundle.Provider, error) {
+	entries := splitList(viper.GetStringSlice(sharesFlag))
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no shares given with --%s", sharesFlag)
+	}
+	shares := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(strings.ToUpper(entry), "VAULT-DUMP-SHARE") {
+			shares = append(shares, entry)
+			continue
+		}
+		data, err := ioutil.ReadFile(entry)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, string(data))
+	}
+	return bundle.NewShamirShares(shares)
+}
+
+// identityList unwraps with whichever identity matches the stanza
+type identityList []bundle.Provider
+
+func (ii identityList) Name() string { return bundle.X25519 }
+
+func (ii identityList) Wrap(key []byte) (*bundle.Stanza, error) {
+	return nil, errors.New("identities cannot be used to encrypt")
+}
+
+func (ii identityList) Unwrap(stanza *bundle.Stanza) ([]byte, error) {
+	var err error
+	for _, id := range ii {
+		var key []byte
+		if key, err = id.Unwrap(stanza); err == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
+
+// splitList splits comma separated entries of a flag or config list, so
+// environment variables can hold several values
+func splitList(values []string) []string {
+	list := make([]string, 0, len(values))
+	for _, vv := range values {
+		for _, ss := range strings.Split(vv, ",") {
+			if ss = strings.TrimSpace(ss); ss != "" {
+				list = append(list, ss)
+			}
+		}
+	}
+	return list
+}
+
+// readPassphrase reads the passphrase from --passphrase-file, or from
+// VAULT_DUMP_PASSPHRASE when no file is given
+func readPassphrase() (string, error) {
+	if path := viper.GetString(passphraseFileFlag); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if passphrase := viper.GetString(passphraseKey); passphrase != "" {
+		return passphrase, nil
+	}
+	return "", fmt.Errorf("error: passphrase must be given with --%s or VAULT_DUMP_PASSPHRASE", passphraseFileFlag)
+}
@@ -1,59 +1,48 @@
 package cmd
 
 import (
-	"fmt"
-	"io/ioutil"
-	"os"
+	"bufio"
+	"io"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	Cmd := &cobra.Command{
-		Short: "Decrypt vault bundle",
-		Use:   "decrypt [flags] <path>",
+		Short: "Decrypt vault bundle or sealed dump",
+		Use:   "decrypt [flags] <path or scheme://location>",
 		Args:  cobra.ExactArgs(1),
 		RunE:  doDecrypt,
 	}
 	Cmd.Flags().StringVarP(&destPath, "output", "o", "", "output path")
+	Cmd.Flags().StringVar(&versionID, "version-id", "", "decrypt this version of the object instead of the current one")
 	rootCmd.AddCommand(Cmd)
 }
 
 func doDecrypt(cmd *cobra.Command, args []string) error {
 	srcPath := args[0]
 
-	data, err := ioutil.ReadFile(srcPath)
+	src, err := storage.GetVersion(srcPath, versionID)
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	dd, err := aws.KMSDecrypt(string(data))
+	plaintext, err := decryptAny(bufio.NewReader(src), "")
 	if err != nil {
 		return err
 	}
-	data = []byte(dd)
 
-	if destPath == "" {
-		fmt.Print(string(data))
-	} else {
-
-		ff, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-
-		err = ff.Chmod(UMASK)
-		if err != nil {
-			return err
-		}
-
-		_, err = ff.Write(data)
-		if err != nil {
-			return err
-		}
+	dst, err := createOutput(destPath)
+	if err != nil {
+		return err
+	}
 
+	if _, err := io.Copy(dst, plaintext); err != nil {
+		dst.Close()
+		return err
 	}
 
-	return nil
+	return dst.Close()
 }
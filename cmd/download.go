@@ -1,11 +1,11 @@
 package cmd
 
 import (
-	"fmt"
+	"bufio"
+	"io"
 	"io/fs"
-	"os"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -14,58 +14,53 @@ const (
 )
 
 var (
-	decrypt  bool
-	destPath string
+	decrypt   bool
+	destPath  string
+	versionID string
 )
 
 func init() {
 	Cmd := &cobra.Command{
 		Short: "Download vault bundle",
-		Use:   "download [flags] s3://<bucket>/<key>",
+		Use:   "download [flags] <scheme>://<location>",
 		Args:  cobra.ExactArgs(1),
 		RunE:  doDownload,
 	}
-	Cmd.Flags().BoolVarP(&decrypt, "decrypt", "d", false, "remove KMS encryption")
+	Cmd.Flags().BoolVarP(&decrypt, "decrypt", "d", false, "decrypt the bundle")
 	Cmd.Flags().StringVarP(&destPath, "output", "o", "", "output path")
+	Cmd.Flags().StringVar(&versionID, "version-id", "", "download this version of the object instead of the current one")
 	rootCmd.AddCommand(Cmd)
 }
 
 func doDownload(cmd *cobra.Command, args []string) error {
-	srcPath := args[0]
+	srcPath, err := resolveLatest(args[0])
+	if err != nil {
+		return err
+	}
 
-	data, err := aws.S3Get(srcPath)
+	body, err := storage.GetVersion(srcPath, versionID)
 	if err != nil {
 		return err
 	}
+	defer body.Close()
 
+	var data io.Reader = body
 	if decrypt {
-		dd, err := aws.KMSDecrypt(string(data))
+		data, err = decryptAny(bufio.NewReader(body), "")
 		if err != nil {
 			return err
 		}
-		data = []byte(dd)
 	}
 
-	if destPath == "" {
-		fmt.Print(string(data))
-	} else {
-
-		ff, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-
-		err = ff.Chmod(UMASK)
-		if err != nil {
-			return err
-		}
-
-		_, err = ff.Write(data)
-		if err != nil {
-			return err
-		}
+	dst, err := createOutput(destPath)
+	if err != nil {
+		return err
+	}
 
+	if _, err := io.Copy(dst, data); err != nil {
+		dst.Close()
+		return err
 	}
 
-	return nil
+	return dst.Close()
 }
@@ -6,26 +6,39 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/compress"
 	"github.com/dathan/go-vault-dump/pkg/dump"
+	"github.com/dathan/go-vault-dump/pkg/lock"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/dathan/go-vault-dump/pkg/vault"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
-	cryptExt   = "aes"
-	destFlag   = "dest"
-	fileFlag   = "filename"
-	kmsKeyFlag = "kms-key"
+	compressFlag         = "compress"
+	cryptExt             = "aes"
+	destFlag             = "dest"
+	encryptValuesFlag    = "encrypt-values"
+	fileFlag             = "filename"
+	fingerprintAuditFlag = "fingerprint-audit"
+	fingerprintKeyFlag   = "fingerprint-key"
+	kmsKeyFlag           = "kms-key"
 )
 
 var (
-	encoding   string
-	kubeconfig string
-	output     string
-	dumpCmd    *cobra.Command
+	encoding      string
+	encryptValues bool
+	fingerprint   bool
+	inventory     bool
+	kubeconfig    string
+	output        string
+	dumpCmd       *cobra.Command
 )
 
 func init() {
@@ -36,21 +49,57 @@ func init() {
 		RunE:  dumpVault,
 	}
 
-	dumpCmd.Flags().StringP(fileFlag, "f", "vault-dump", "output filename (.json or .yaml extension will be added)")
-	dumpCmd.Flags().String(kmsKeyFlag, "", "KMS encryption key ARN (required for S3 uploads)")
-	dumpCmd.Flags().StringP(destFlag, "d", "", "output directory or S3 path")
-	dumpCmd.Flags().StringVarP(&encoding, "encoding", "e", "json", "encoding type [json, yaml]")
-	dumpCmd.Flags().StringVarP(&output, "output", "o", "file", "output type, [stdout, file, s3]")
+	dumpCmd.Flags().StringP(fileFlag, "f", "vault-dump", "output filename, may be a template (.json or .yaml extension will be added)")
+	dumpCmd.Flags().StringSlice(kmsKeyFlag, []string{}, "KMS encryption key ARN, may be repeated (required for uploads encrypted with kms)")
+	dumpCmd.Flags().String(compressFlag, "", "compress uploads before encrypting [gzip, zstd]")
+	dumpCmd.Flags().String(encryptWithFlag, bundle.KMS, "encryption provider for uploads [kms, x25519, passphrase, transit, shamir]")
+	dumpCmd.Flags().StringSlice(recipientFlag, []string{}, "X25519 public key to encrypt uploads for, may be repeated")
+	dumpCmd.Flags().String(transitKeyFlag, "", "vault transit key name used by --encrypt-with transit")
+	dumpCmd.Flags().StringToString(kmsContextFlag, map[string]string{}, "extra KMS encryption context pairs, key=value[,...]")
+	dumpCmd.Flags().Int(splitFlag, 0, "number of shares to split the key into with --encrypt-with shamir")
+	dumpCmd.Flags().Int(thresholdFlag, 0, "number of shares needed to decrypt with --encrypt-with shamir")
+	dumpCmd.Flags().String(sharesDirFlag, "", "local directory to write shamir shares to (default print them)")
+	dumpCmd.Flags().String(kdfFlag, "", "passphrase key derivation [argon2id, scrypt] (default argon2id)")
+	dumpCmd.Flags().StringP(destFlag, "d", "", "output directory, or URL to upload to [s3://, file://, http(s)://], may be a template")
+	dumpCmd.Flags().StringSlice(replicateToFlag, []string{}, "URL to copy each upload and its manifest to, may be a template, may be repeated")
+	dumpCmd.Flags().StringToString(replicateKMSKeyFlag, map[string]string{}, "KMS key ARN to wrap the data key for at a --replicate-to destination, destination=arn[,...]")
+	dumpCmd.Flags().StringToString(replicateRegionFlag, map[string]string{}, "AWS region of the bucket of a --replicate-to destination, destination=region[,...]")
+	dumpCmd.Flags().String(latestFlag, "", "location of the pointer to the newest upload, may be a template (default latest above the first template token of --dest)")
+	dumpCmd.Flags().StringVarP(&encoding, "encoding", "e", "json", "encoding type [json, yaml, csv (inventory only)]")
+	dumpCmd.Flags().BoolVar(&inventory, "inventory", false, "record paths, key names and value lengths without secret values")
+	dumpCmd.Flags().StringVarP(&output, "output", "o", "file", "output type, [stdout, file, s3 (any upload URL)]")
 	dumpCmd.Flags().StringVarP(&kubeconfig, "kubeconfig", "k", "", "location of kube config file")
+	dumpCmd.Flags().BoolVar(&encryptValues, encryptValuesFlag, false, "encrypt each value individually, leaving paths and key names readable")
+	dumpCmd.Flags().BoolVar(&fingerprint, "fingerprint", false, "replace every value with a keyed fingerprint for drift detection")
+	dumpCmd.Flags().String(fingerprintKeyFlag, "", "HMAC key used by --fingerprint")
+	dumpCmd.Flags().String(fingerprintAuditFlag, "", "fingerprint with sys/audit-hash of the audit device at this path instead of an HMAC key")
+
+	addS3PutFlags(dumpCmd)
+	addLockFlags(dumpCmd)
 
 	viper.BindPFlag(fileFlag, dumpCmd.Flags().Lookup(fileFlag))
 	viper.BindPFlag(destFlag, dumpCmd.Flags().Lookup(destFlag))
+	viper.BindPFlag(latestFlag, dumpCmd.Flags().Lookup(latestFlag))
+	viper.BindPFlag(replicateToFlag, dumpCmd.Flags().Lookup(replicateToFlag))
+	viper.BindPFlag(replicateKMSKeyFlag, dumpCmd.Flags().Lookup(replicateKMSKeyFlag))
+	viper.BindPFlag(replicateRegionFlag, dumpCmd.Flags().Lookup(replicateRegionFlag))
 	viper.BindPFlag(kmsKeyFlag, dumpCmd.Flags().Lookup(kmsKeyFlag))
+	viper.BindPFlag(compressFlag, dumpCmd.Flags().Lookup(compressFlag))
+	viper.BindPFlag(encryptWithFlag, dumpCmd.Flags().Lookup(encryptWithFlag))
+	viper.BindPFlag(recipientFlag, dumpCmd.Flags().Lookup(recipientFlag))
+	viper.BindPFlag(kdfFlag, dumpCmd.Flags().Lookup(kdfFlag))
+	viper.BindPFlag(kmsContextFlag, dumpCmd.Flags().Lookup(kmsContextFlag))
+	viper.BindPFlag(transitKeyFlag, dumpCmd.Flags().Lookup(transitKeyFlag))
+	viper.BindPFlag(splitFlag, dumpCmd.Flags().Lookup(splitFlag))
+	viper.BindPFlag(thresholdFlag, dumpCmd.Flags().Lookup(thresholdFlag))
+	viper.BindPFlag(sharesDirFlag, dumpCmd.Flags().Lookup(sharesDirFlag))
+	viper.BindPFlag(fingerprintKeyFlag, dumpCmd.Flags().Lookup(fingerprintKeyFlag))
+	viper.BindPFlag(fingerprintAuditFlag, dumpCmd.Flags().Lookup(fingerprintAuditFlag))
 
 	rootCmd.AddCommand(dumpCmd)
 }
 
-func dumpVault(cmd *cobra.Command, args []string) error {
+func dumpVault(cmd *cobra.Command, args []string) (err error) {
 
 	paths := args[0]
 
@@ -67,29 +116,91 @@ func dumpVault(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	outputPath := viper.GetString(destFlag)
-	if len(outputPath) > 5 && outputPath[:5] == "s3://" {
+	names := dump.NewNameData(viper.GetString(vaFlag), paths, time.Now())
+	outputPath, err := dump.ExpandName(viper.GetString(destFlag), names)
+	if err != nil {
+		return fmt.Errorf("error: --%s: %w", destFlag, err)
+	}
+	outputFilename, err := dump.ExpandName(viper.GetString(fileFlag), names)
+	if err != nil {
+		return fmt.Errorf("error: --%s: %w", fileFlag, err)
+	}
+	if storage.Scheme(outputPath) != "" {
 		output = "s3"
 	}
 
-	s3path := ""
-	kmsKey := viper.GetString(kmsKeyFlag)
+	remotePath, latest := "", ""
+	var (
+		targets []replica
+		lk      *lock.Lock
+	)
+	crypt := &cryptOptions{
+		Provider:    viper.GetString(encryptWithFlag),
+		KMSKeys:     splitList(viper.GetStringSlice(kmsKeyFlag)),
+		Recipients:  splitList(viper.GetStringSlice(recipientFlag)),
+		TransitKey:  viper.GetString(transitKeyFlag),
+		KDF:         viper.GetString(kdfFlag),
+		Compression: viper.GetString(compressFlag),
+		Split:       viper.GetInt(splitFlag),
+		Threshold:   viper.GetInt(thresholdFlag),
+		SharesDir:   viper.GetString(sharesDirFlag),
+		Context:     viper.GetStringMapString(kmsContextFlag),
+	}
 	if output == "s3" {
-		if kmsKey == "" {
-			return errors.New("error: KMS key must be specified for S3 upload")
+		if !fingerprint {
+			if err := crypt.validate(); err != nil {
+				return err
+			}
+		}
+		if err := compress.Validate(crypt.Compression); err != nil {
+			return err
+		}
+		if err := applyS3PutOptions(cmd); err != nil {
+			return err
 		}
 		if outputPath == "" {
-			return errors.New("error: Must specify an output path for S3 upload")
+			return errors.New("error: Must specify an output URL for upload")
 		}
-		s3path = vault.EnsureNoTrailingSlash(outputPath)
-		if len(s3path) < 5 || s3path[:5] != "s3://" {
-			return errors.New("error: Output path for S3 upload must begin with s3://")
+		remotePath = vault.EnsureNoTrailingSlash(outputPath)
+		if storage.Scheme(remotePath) == "" {
+			return fmt.Errorf("error: Output path for upload must be a URL with one of the schemes %v", storage.Schemes())
 		}
+		if _, _, err := storage.Open(remotePath); err != nil {
+			return fmt.Errorf("error: %w", err)
+		}
+		latest, err = latestPointer(vault.EnsureNoTrailingSlash(viper.GetString(destFlag)), names)
+		if err != nil {
+			return err
+		}
+		targets, err = replicas(splitList(viper.GetStringSlice(replicateToFlag)), viper.GetStringMapString(replicateKMSKeyFlag), viper.GetStringMapString(replicateRegionFlag), names)
+		if err != nil {
+			return err
+		}
+
+		// overlapping runs share the lock of the part of --dest that does
+		// not change from run to run
+		lockPrefix := remotePath
+		if dest := viper.GetString(destFlag); dump.IsTemplate(dest) {
+			lockPrefix = dump.StaticPrefix(dest)
+		}
+		var lockErr error
+		lk, lockErr = acquireLock(cmd, lockPrefix)
+		if lockErr != nil {
+			return lockErr
+		}
+		defer func() {
+			if unlockErr := lk.Release(); unlockErr != nil && err == nil {
+				err = unlockErr
+			}
+		}()
 		outputPath, err = ioutil.TempDir("", "vault-dump-*")
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
+	if output != "s3" && len(viper.GetStringSlice(replicateToFlag)) > 0 {
+		return fmt.Errorf("error: --%s requires an upload, give a URL with --%s", replicateToFlag, destFlag)
+	}
 	defer func() {
 		if output == "s3" {
 			os.RemoveAll(outputPath)
@@ -106,40 +217,133 @@ func dumpVault(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	outputFilename := viper.GetString(fileFlag)
+	var fingerprinter dump.Fingerprinter
+	if fingerprint {
+		fingerprinter, err = newFingerprinter(vc)
+		if err != nil {
+			return err
+		}
+	}
+
+	crypt = crypt.withContext("vault_addr", viper.GetString(vaFlag))
+	crypt = crypt.withContext("filename", fmt.Sprintf("%s.%s", outputFilename, encoding))
+
+	var sealer dump.Sealer
+	if encryptValues {
+		if remotePath != "" {
+			crypt = crypt.withContext(objectContext(fmt.Sprintf("%s/%s.%s", remotePath, outputFilename, encoding)))
+		}
+		sealer, err = newSealedKey(crypt)
+		if err != nil {
+			return err
+		}
+	}
+
 	dumper, err := dump.New(&dump.Config{
 		Debug:       Verbose,
 		InputPath:   paths,
 		Filename:    outputFilename,
+		Fingerprint: fingerprinter,
+		Inventory:   inventory,
 		Output:      outputConfig,
+		Seal:        sealer,
 		VaultConfig: vc,
 	})
 	if err != nil {
 		return err
 	}
 
+	startedAt := time.Now().UTC()
 	if err := dumper.Secrets(); err != nil {
 		return err
 	}
 
 	if output == "s3" {
 		srcPath := fmt.Sprintf("%s/%s.%s", outputPath, outputFilename, encoding)
-		dstPath := fmt.Sprintf("%s/%s.%s.%s", s3path, outputFilename, encoding, cryptExt)
-		plaintext, err := ioutil.ReadFile(srcPath)
+		dstPath := fmt.Sprintf("%s/%s.%s.%s", remotePath, outputFilename, encoding, cryptExt)
+		plaintext, err := os.Open(srcPath)
 		if err != nil {
 			// This is expected if no secrets were dumped
 			log.Println("Nothing to upload")
 			return nil
 		}
-		ciphertext, err := aws.KMSEncrypt(string(plaintext), kmsKey)
-		if err != nil {
+		defer plaintext.Close()
+
+		mm := manifest.New(version)
+		mm.VaultAddr = viper.GetString(vaFlag)
+		mm.Paths = strings.Split(paths, ",")
+		mm.IgnoreKeys = viper.GetStringSlice(ignoreKeysFlag)
+		mm.IgnorePaths = viper.GetStringSlice(ignorePathsFlag)
+		mm.SecretCount = dumper.Count()
+		mm.Kind = dumpKind()
+		mm.StartedAt = startedAt.Format(time.RFC3339)
+
+		// every write below checks the lock is still held, so a run that
+		// lost it does not overwrite what the new holder writes
+		if err := lk.Lost(); err != nil {
 			return err
 		}
-		err = aws.S3Put(dstPath, ciphertext)
+		plainSum := manifest.NewHasher()
+		src := plainSum.TeeReader(plaintext)
+		if fingerprint || encryptValues {
+			// fingerprints carry no secret material and sealed values are
+			// already encrypted, both are stored as-is so they can be diffed
+			dstPath = fmt.Sprintf("%s/%s.%s", remotePath, outputFilename, encoding)
+			mm.Ciphertext, err = uploadFrom(src, dstPath, nil)
+		} else {
+			mm.Encrypted = true
+			mm.Ciphertext, err = uploadFrom(src, dstPath, crypt)
+		}
 		if err != nil {
 			return err
 		}
+		mm.Object = manifestObject(dstPath)
+		mm.Plaintext = plainSum.Sum()
+		mm.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := lk.Lost(); err != nil {
+			return err
+		}
+		if err := writeManifest(dstPath, mm); err != nil {
+			return fmt.Errorf("error writing manifest: %w", err)
+		}
+		if err := lk.Lost(); err != nil {
+			return err
+		}
+		if err := writeLatest(latest, dstPath); err != nil {
+			return fmt.Errorf("error updating %s: %w", latest, err)
+		}
+		if len(targets) > 0 {
+			if err := lk.Lost(); err != nil {
+				return err
+			}
+			return replicateDump(dstPath, targets)
+		}
 	}
 
 	return nil
 }
+
+// dumpKind is the kind of dump recorded in the manifest
+func dumpKind() string {
+	switch {
+	case inventory:
+		return manifest.KindInventory
+	case fingerprint:
+		return manifest.KindFingerprint
+	case encryptValues:
+		return manifest.KindSealed
+	}
+	return manifest.KindBackup
+}
+
+// newFingerprinter picks the audit-hash or HMAC fingerprinter from flags
+func newFingerprinter(vc *vault.Config) (dump.Fingerprinter, error) {
+	if audit := viper.GetString(fingerprintAuditFlag); audit != "" {
+		return dump.NewAuditHashFingerprinter(vc.Client, audit)
+	}
+	key := viper.GetString(fingerprintKeyFlag)
+	if key == "" {
+		return nil, errors.New("error: --fingerprint requires --fingerprint-key or --fingerprint-audit")
+	}
+	return dump.NewHMACFingerprinter([]byte(key))
+}
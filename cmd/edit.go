@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/sealed"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Short: "Edit a sealed dump in $EDITOR",
+		Use:   "edit [flags] <path>",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doEdit,
+	}
+	rootCmd.AddCommand(Cmd)
+}
+
+func doEdit(cmd *cobra.Command, args []string) error {
+	srcPath := args[0]
+
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	doc, err := readDocument(data)
+	if err != nil {
+		return err
+	}
+	if !sealed.IsSealed(doc) {
+		return fmt.Errorf("error: %s is not a sealed dump", srcPath)
+	}
+	plaintext, key, err := openDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	encoding := documentEncoding(data)
+	before, err := encodeDocument(plaintext, encoding)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "vault-dump-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "vault-dump."+encoding)
+	if err := writeTempFile(tmpPath, strings.NewReader(before)); err != nil {
+		return err
+	}
+	if err := runEditor(tmpPath); err != nil {
+		return err
+	}
+
+	after, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(after, []byte(before)) {
+		log.Println("No changes made")
+		return nil
+	}
+
+	edited, err := readDocument(after)
+	if err != nil {
+		return fmt.Errorf("error: edited document is invalid, %s left unchanged: %w", srcPath, err)
+	}
+	resealed, err := key.Seal(edited)
+	if err != nil {
+		return err
+	}
+	out, err := encodeDocument(resealed, encoding)
+	if err != nil {
+		return err
+	}
+
+	// replace the sealed dump atomically so a failed write cannot lose it
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	newPath := srcPath + ".new"
+	if err := ioutil.WriteFile(newPath, []byte(out), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(newPath, srcPath)
+}
+
+// runEditor opens path in $EDITOR, vi when it is not set
+func runEditor(path string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	fields := strings.Fields(editor)
+	if len(fields) == 0 {
+		return errors.New("error: $EDITOR is empty")
+	}
+
+	ed := exec.Command(fields[0], append(fields[1:], path)...)
+	ed.Stdin = os.Stdin
+	ed.Stdout = os.Stdout
+	ed.Stderr = os.Stderr
+	if err := ed.Run(); err != nil {
+		return fmt.Errorf("error running %s: %w", editor, err)
+	}
+	return nil
+}
+
+// writeTempFile streams r into a new file readable only by the current user
+func writeTempFile(path string, r io.Reader) error {
+	ff, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, UMASK)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ff, r); err != nil {
+		ff.Close()
+		return err
+	}
+	return ff.Close()
+}
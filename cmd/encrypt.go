@@ -1,17 +1,24 @@
 package cmd
 
 import (
-	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
 	"github.com/spf13/cobra"
 )
 
 var (
-	keyArn string
+	compression string
+	contextKV   map[string]string
+	encryptWith string
+	kdf         string
+	keyArns     []string
+	recipients  []string
+	sharesDir   string
+	split       int
+	threshold   int
+	transitKey  string
 )
 
 func init() {
@@ -22,47 +29,62 @@ func init() {
 		RunE:  doEncrypt,
 	}
 	Cmd.Flags().StringVarP(&destPath, "output", "o", "", "output path")
-	Cmd.Flags().StringVarP(&keyArn, "key", "k", "", "KMS key ARN")
+	Cmd.Flags().StringSliceVarP(&keyArns, "key", "k", []string{}, "KMS key ARN, may be repeated to wrap the data key for keys in other regions or accounts")
+	Cmd.Flags().StringVar(&compression, compressFlag, "", "compress before encrypting [gzip, zstd]")
+	Cmd.Flags().StringVar(&encryptWith, encryptWithFlag, "", "encryption provider [kms, x25519, passphrase, transit, shamir] (default kms)")
+	Cmd.Flags().StringSliceVar(&recipients, recipientFlag, []string{}, "X25519 public key to encrypt for, may be repeated")
+	Cmd.Flags().StringVar(&transitKey, transitKeyFlag, "", "vault transit key name")
+	Cmd.Flags().StringToStringVar(&contextKV, kmsContextFlag, map[string]string{}, "extra KMS encryption context pairs, key=value[,...]")
+	Cmd.Flags().IntVar(&split, splitFlag, 0, "number of shares to split the key into with --encrypt-with shamir")
+	Cmd.Flags().IntVar(&threshold, thresholdFlag, 0, "number of shares needed to decrypt with --encrypt-with shamir")
+	Cmd.Flags().StringVar(&sharesDir, sharesDirFlag, "", "directory to write shamir shares to (default print them)")
+	Cmd.Flags().StringVar(&kdf, kdfFlag, "", "passphrase key derivation [argon2id, scrypt] (default argon2id)")
 	rootCmd.AddCommand(Cmd)
 }
 
 func doEncrypt(cmd *cobra.Command, args []string) error {
 	srcPath := args[0]
 
-	if keyArn == "" {
-		return errors.New("error: KMS key ARN must be specified")
+	opts := &cryptOptions{
+		Provider:    encryptWith,
+		KMSKeys:     keyArns,
+		Recipients:  recipients,
+		TransitKey:  transitKey,
+		KDF:         kdf,
+		Compression: compression,
+		Split:       split,
+		Threshold:   threshold,
+		SharesDir:   sharesDir,
+		Context:     contextKV,
+	}
+	opts = opts.withContext("filename", filepath.Base(srcPath))
+	if err := opts.validate(); err != nil {
+		return err
 	}
 
-	plaintext, err := ioutil.ReadFile(srcPath)
+	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	ciphertext, err := aws.KMSEncrypt(string(plaintext), keyArn)
+	dst, err := createOutput(destPath)
 	if err != nil {
 		return err
 	}
+	defer dst.Close()
 
-	if destPath == "" {
-		fmt.Print(string(ciphertext))
-	} else {
-
-		ff, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-
-		err = ff.Chmod(UMASK)
-		if err != nil {
-			return err
-		}
+	ciphertext, err := encryptWriter(dst, opts)
+	if err != nil {
+		return err
+	}
 
-		data := []byte(ciphertext)
-		_, err = ff.Write(data)
-		if err != nil {
-			return err
-		}
+	if _, err := io.Copy(ciphertext, src); err != nil {
+		return err
+	}
+	if err := ciphertext.Close(); err != nil {
+		return err
 	}
 
-	return nil
+	return dst.Close()
 }
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dathan/go-vault-dump/pkg/load"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	getField   string
+	getRestore bool
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Use:   "get [flags] <path or scheme://location> <secret path>",
+		Short: "Print or restore one secret from a bundle",
+		Long: `Print or restore one secret from a bundle
+
+The bundle is decrypted in memory and checked against its manifest like
+import, and the secret at the path, as it is named in the dump, is printed as
+JSON, or only the value of --field. With --restore, the secret is written to
+Vault instead, replacing its current data.`,
+		Args:         cobra.ExactArgs(2),
+		RunE:         doGet,
+		SilenceUsage: true,
+	}
+	Cmd.Flags().StringVar(&getField, "field", "", "print only the value of this key of the secret")
+	Cmd.Flags().BoolVar(&getRestore, "restore", false, "write the secret to Vault instead of printing it")
+	Cmd.Flags().StringVar(&versionID, "version-id", "", "read this version of the object instead of the current one")
+	rootCmd.AddCommand(Cmd)
+}
+
+func doGet(cmd *cobra.Command, args []string) error {
+	if getRestore && getField != "" {
+		return errors.New("error: --restore writes the whole secret, give either --field or --restore")
+	}
+
+	location, err := resolveLatest(args[0])
+	if err != nil {
+		return err
+	}
+	secrets, err := readSecrets(location, versionID)
+	if err != nil {
+		return err
+	}
+	path, secret, ok := lookupSecret(secrets, args[1])
+	if !ok {
+		return fmt.Errorf("error: %s is not in %s", args[1], location)
+	}
+
+	if getRestore {
+		return restoreSecret(path, secret)
+	}
+
+	var value interface{} = secret
+	if getField != "" {
+		data, isMap := secret.(map[string]interface{})
+		if !isMap {
+			return fmt.Errorf("error: %s has no fields", path)
+		}
+		if value, ok = data[getField]; !ok {
+			return fmt.Errorf("error: %s has no field %q", path, getField)
+		}
+		if str, isString := value.(string); isString {
+			fmt.Println(str)
+			return nil
+		}
+	}
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// lookupSecret returns the secret at path in secrets, paths compare without
+// leading and trailing slashes
+func lookupSecret(secrets map[string]interface{}, path string) (string, interface{}, bool) {
+	want := vault.EnsureNoLeadingSlash(vault.EnsureNoTrailingSlash(path))
+	for kk, vv := range secrets {
+		if vault.EnsureNoLeadingSlash(vault.EnsureNoTrailingSlash(kk)) == want {
+			return kk, vv, true
+		}
+	}
+	return "", nil, false
+}
+
+// restoreSecret writes the secret at path to the vault given with
+// --vault-addr, through the same loader as import
+func restoreSecret(path string, secret interface{}) error {
+	vc, err := vault.NewClient(&vault.Config{
+		Address: viper.GetString(vaFlag),
+		Retries: 5,
+		Token:   viper.GetString(vtFlag),
+		Ignore:  &vault.Ignore{},
+	})
+	if err != nil {
+		return err
+	}
+	loader, err := load.New(&load.Config{VaultConfig: vc})
+	if err != nil {
+		return err
+	}
+	if err := loader.FromSecrets(map[string]interface{}{path: secret}); err != nil {
+		return err
+	}
+	if loader.Failed() > 0 {
+		return fmt.Errorf("error: failed to restore %s", path)
+	}
+	log.Printf("Restored %s to %s", path, viper.GetString(vaFlag))
+	return nil
+}
@@ -1,38 +1,69 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"os"
+	"log"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
-	"github.com/dathan/go-vault-dump/pkg/file"
 	"github.com/dathan/go-vault-dump/pkg/load"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/dathan/go-vault-dump/pkg/vault"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	Brute     bool
-	importCmd *cobra.Command
+	Brute            bool
+	importCmd        *cobra.Command
+	importAsOf       string
+	importPaths      []string
+	importSaveFailed bool
 )
 
 func init() {
 	importCmd = &cobra.Command{
 		Use:   "import [flags] <filename>",
 		Short: "Import secrets to Vault",
-		Args:  cobra.ExactArgs(1),
-		RunE:  importVault,
+		Long: `Import secrets to Vault
+
+With --as-of, the argument is a prefix instead and the newest bundle under it
+taken at or before that time is imported, going by the completion time in its
+manifest, or the time the object was written when it has none. --path limits
+the import to some of the secrets in the bundle.`,
+		Args: cobra.ExactArgs(1),
+		RunE: importVault,
 	}
 	importCmd.Flags().BoolVarP(&Brute, "brute", "", false, "retry failed indefinitely")
+	importCmd.Flags().StringVar(&versionID, "version-id", "", "import this version of the object instead of the current one")
+	importCmd.Flags().StringVar(&importAsOf, "as-of", "", "import the newest bundle under the prefix taken at or before this time (RFC3339 or YYYY-MM-DD)")
+	importCmd.Flags().StringSliceVar(&importPaths, "path", []string{}, "only import secrets at or below these paths, may be repeated")
+	importCmd.Flags().BoolVar(&importSaveFailed, "save-failed", false, "write the secrets that fail to import, values included, to a json file in the working directory to retry them")
 	importCmd.Flags().ParseErrorsWhitelist.UnknownFlags = true
 	rootCmd.AddCommand(importCmd)
 }
 
 func importVault(cmd *cobra.Command, args []string) error {
 
+	asOf, err := parseListTime(importAsOf)
+	if err != nil {
+		return fmt.Errorf("error: --as-of: %w", err)
+	}
+	if !asOf.IsZero() && versionID != "" {
+		return errors.New("error: give either --as-of or --version-id")
+	}
+	if !asOf.IsZero() {
+		if err := storage.Listable(args[0]); err != nil {
+			return fmt.Errorf("error: --as-of: %w", err)
+		}
+	}
+
 	retries := 5
 	if Brute {
 		retries = 0
@@ -54,45 +85,171 @@ func importVault(cmd *cobra.Command, args []string) error {
 	loader, err := load.New(
 		&load.Config{
 			VaultConfig: vc,
+			Paths:       importPaths,
+			SaveFailed:  importSaveFailed,
 		},
 	)
 	if err != nil {
 		return err
 	}
 
-	filepath := args[0]
-	fromS3 := len(filepath) > 5 && filepath[:5] == "s3://"
-	tmpDir := ""
+	var filepath string
+	if asOf.IsZero() {
+		filepath, err = resolveLatest(args[0])
+	} else {
+		filepath, err = bundleAsOf(args[0], asOf)
+	}
+	if err != nil {
+		return err
+	}
+	secrets, err := readSecrets(filepath, versionID)
+	if err != nil {
+		return err
+	}
+	if err := loader.FromSecrets(secrets); err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	if fromS3 {
-		encrypted, err := aws.S3Get(filepath)
-		if err != nil {
-			return err
-		}
-		plaintext, err := aws.KMSDecrypt(string(encrypted))
-		if err != nil {
-			return err
+// isUploadKey reports whether key may name an upload of dump: an encrypted
+// bundle, or a sealed or unencrypted dump, but not a manifest
+func isUploadKey(key string) bool {
+	if strings.HasSuffix(key, manifest.Ext) {
+		return false
+	}
+	for _, ext := range []string{cryptExt, "json", "yaml"} {
+		if strings.HasSuffix(key, "."+ext) {
+			return true
 		}
-		tmpDir, err = ioutil.TempDir("", "vault-dump-*")
+	}
+	return false
+}
+
+// readSecrets reads the dump at location, or version of it, into memory:
+// uploaded exports, local bundles and sealed dumps are decrypted on the way
+// and checked against their manifest, plain local dumps are read as they
+// are. The plaintext is never written to disk.
+func readSecrets(location string, version string) (map[string]interface{}, error) {
+	if storage.Scheme(location) == "file" {
+		_, path, err := storage.Open(location)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error: %w", err)
 		}
+		location = path
+	}
+
+	src, err := storage.GetVersion(location, version)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
 
-		defer os.RemoveAll(tmpDir)
+	br := bufio.NewReader(src)
+	peek, _ := br.Peek(512)
+	if !storage.IsRemote(location) && isDocument(peek) && !isSealedFile(location) {
+		return load.ReadSecrets(br)
+	}
+
+	mm, err := readManifestVersion(location, version)
+	if err != nil {
+		return nil, err
+	}
+	objectSum := manifest.NewHasher()
+	br = bufio.NewReader(objectSum.TeeReader(br))
 
-		pathslices := strings.Split(filepath, "/")
-		filename := pathslices[len(pathslices)-1]
-		filepath = fmt.Sprintf("%s/%s", vault.EnsureNoTrailingSlash(tmpDir), filename)
-		ok := file.WriteFile(filepath, plaintext)
-		if !ok {
-			os.RemoveAll(tmpDir)
-			return fmt.Errorf("error writing %s", filepath)
+	plaintext, err := decryptAny(br, "json")
+	if err != nil {
+		return nil, err
+	}
+	plainSum := manifest.NewHasher()
+	secrets, err := load.ReadSecrets(plainSum.TeeReader(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", location, err)
+	}
+
+	if mm != nil {
+		// hash anything the decoder left unread so the whole object is checked
+		if _, err := io.Copy(ioutil.Discard, br); err != nil {
+			return nil, err
+		}
+		if err := checkManifest(mm, location, objectSum, plainSum); err != nil {
+			return nil, fmt.Errorf("error: refusing to restore from %s: %w", location, err)
 		}
+		log.Printf("Checksums match the manifest taken %s from %s", mm.CompletedAt, mm.VaultAddr)
+	} else {
+		log.Printf("%s has no manifest, checksums not verified", location)
 	}
+	return secrets, nil
+}
 
-	if err := loader.FromFile(filepath); err != nil {
-		return err
+// bundleAsOf returns the newest bundle under the directory prefix taken at
+// or before asOf, by the completion time in its manifest or else the time the
+// object was written. Encrypted bundles are recognised by their extension,
+// sealed and unencrypted uploads by their manifest. Bundles whose manifest
+// does not verify, or records a dump that cannot be restored such as a
+// fingerprint or inventory, are passed over.
+func bundleAsOf(prefix string, asOf time.Time) (string, error) {
+	store, key, err := storage.Open(prefix)
+	if err != nil {
+		return "", fmt.Errorf("error: %w", err)
+	}
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	objects, err := store.List(key)
+	if err != nil {
+		return "", err
+	}
+	bundles := []storage.Object{}
+	for _, obj := range objects {
+		if isUploadKey(obj.Key) {
+			bundles = append(bundles, obj)
+		}
 	}
+	sort.Slice(bundles, func(ii, jj int) bool {
+		return bundles[ii].LastModified.After(bundles[jj].LastModified)
+	})
 
-	return nil
+	best, bestTime, source := "", time.Time{}, ""
+	for _, obj := range bundles {
+		if best != "" && !obj.LastModified.After(bestTime) {
+			// a dump completes before its bundle is written, so no older
+			// object can have been taken later than the one found
+			break
+		}
+		location := store.URL(obj.Key)
+		taken, from := obj.LastModified, "object"
+		mm, err := readManifest(location)
+		if err != nil {
+			log.Printf("Skipping %s: %s", location, err)
+			continue
+		}
+		encrypted := strings.HasSuffix(obj.Key, "."+cryptExt)
+		if mm == nil && !encrypted {
+			continue // not known to be a backup
+		}
+		// fingerprints and inventories hold no secrets to restore, encrypted
+		// bundles from before the kind was recorded are taken as backups
+		if mm != nil && !mm.Restorable() && (mm.Kind != "" || !encrypted) {
+			log.Printf("Skipping %s: not recorded as a restorable backup", location)
+			continue
+		}
+		if mm != nil {
+			if completed, err := time.Parse(time.RFC3339, mm.CompletedAt); err == nil {
+				taken, from = completed, "manifest"
+			}
+		}
+		if taken.After(asOf) || (best != "" && !taken.After(bestTime)) {
+			continue
+		}
+		best, bestTime, source = location, taken, from
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("error: no backup under %s was taken at or before %s", prefix, asOf.UTC().Format(time.RFC3339))
+	}
+	log.Printf("Selected %s taken %s (%s time) for --as-of %s", best, bestTime.UTC().Format(time.RFC3339), source, asOf.UTC().Format(time.RFC3339))
+	return best, nil
 }
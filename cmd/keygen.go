@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Short: "Generate an X25519 identity for offline bundles",
+		Use:   "keygen [flags]",
+		Args:  cobra.NoArgs,
+		RunE:  doKeygen,
+	}
+	Cmd.Flags().StringVarP(&destPath, "output", "o", "", "identity file path")
+	rootCmd.AddCommand(Cmd)
+}
+
+func doKeygen(cmd *cobra.Command, args []string) error {
+	identity, recipient, err := bundle.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+
+	if destPath != "" {
+		if _, err := os.Stat(destPath); err == nil {
+			return fmt.Errorf("error: %s already exists", destPath)
+		}
+	}
+
+	dst, err := createOutput(destPath)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(dst, "# public key: %s\n%s\n", recipient, identity); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if destPath != "" {
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", recipient)
+	}
+	return nil
+}
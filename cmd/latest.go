@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/dump"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+	"github.com/spf13/viper"
+)
+
+const (
+	latestFlag = "latest"
+	latestName = "latest"
+	// latestMaxSize bounds what is read when checking for a pointer
+	latestMaxSize = 4096
+)
+
+// latestPointer returns the location of the pointer to the newest upload
+// under dest, --latest when given, otherwise "latest" in the part of dest
+// above its first template token
+func latestPointer(dest string, data *dump.NameData) (string, error) {
+	pointer := vault.EnsureNoTrailingSlash(dump.StaticPrefix(dest)) + "/" + latestName
+	if custom := viper.GetString(latestFlag); custom != "" {
+		expanded, err := dump.ExpandName(custom, data)
+		if err != nil {
+			return "", fmt.Errorf("error: --%s: %w", latestFlag, err)
+		}
+		pointer = expanded
+	}
+	if _, _, err := storage.Open(pointer); err != nil {
+		return "", fmt.Errorf("error: cannot store the latest pointer at %s, give its location with --%s", storage.Redact(pointer), latestFlag)
+	}
+	return pointer, nil
+}
+
+// writeLatest points the pointer at target, without the credentials of
+// target which readers give with the location of the pointer
+func writeLatest(pointer string, target string) error {
+	target = storage.Redact(target)
+	if err := storage.WriteAll(pointer, []byte(target+"\n")); err != nil {
+		return err
+	}
+	log.Printf("Updated %s to point to %s", storage.Redact(pointer), target)
+	return nil
+}
+
+// resolveLatest returns the target of location when it is a latest pointer,
+// and location itself otherwise
+func resolveLatest(location string) (string, error) {
+	if path.Base(location) != latestName {
+		return location, nil
+	}
+	data, err := storage.ReadAll(location)
+	if err != nil {
+		return "", err
+	}
+	target := strings.TrimSpace(string(data))
+	if len(data) > latestMaxSize || target == "" || strings.ContainsAny(target, "\n{\x00") {
+		// not a pointer, such as a dump saved as "latest"
+		return location, nil
+	}
+	log.Printf("Resolved %s to %s", storage.Redact(location), target)
+	// the pointer is stored without credentials, those given for it are
+	// used for a target on the same server
+	return storage.WithCredentials(target, location), nil
+}
@@ -1,27 +1,79 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
-	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/spf13/cobra"
 	"golang.org/x/text/message"
 )
 
+const (
+	// bundleInfoSize is enough to hold the unencrypted header of a bundle
+	bundleInfoSize = 64 * 1024
+)
+
 var (
-	listCmd *cobra.Command
+	listCmd         *cobra.Command
+	listCompression bool
+	listManifest    bool
+	listSort        string
+	listReverse     bool
+	listSince       string
+	listUntil       string
+	listLimit       int
+	listOutput      string
+	listVersions    bool
 )
 
+// listEntry is one bundle in the output of list
+type listEntry struct {
+	Key          string            `json:"key"`
+	Location     string            `json:"location"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"last_modified"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	VersionID    string            `json:"version_id,omitempty"`
+	IsLatest     bool              `json:"is_latest,omitempty"`
+	DeleteMarker bool              `json:"delete_marker,omitempty"`
+	Compression  string            `json:"compression,omitempty"`
+	Manifest     *listManifestInfo `json:"manifest,omitempty"`
+}
+
+// listManifestInfo is what list shows of a bundle manifest
+type listManifestInfo struct {
+	Taken   string `json:"taken,omitempty"`
+	Vault   string `json:"vault,omitempty"`
+	Secrets int    `json:"secrets"`
+	Status  string `json:"status"`
+}
+
 func init() {
 	listCmd = &cobra.Command{
-		Use:   "list s3://<bucket>/[path]",
-		Short: "Lists vault state files in an S3 bucket",
+		Use:   "list <scheme>://<location>/[prefix]",
+		Short: "Lists vault state files in a bucket or directory",
 		Args:  cobra.ExactArgs(1),
 		RunE:  listExports,
 	}
+	listCmd.Flags().BoolVarP(&listCompression, "compression", "c", false, "read each bundle header and show its compression")
+	listCmd.Flags().BoolVarP(&listManifest, "manifest", "m", false, "read each bundle manifest and show when and where it was taken")
+	listCmd.Flags().StringVar(&listSort, "sort", "key", "sort by [key, size, time]")
+	listCmd.Flags().BoolVarP(&listReverse, "reverse", "r", false, "reverse the sort order")
+	listCmd.Flags().StringVar(&listSince, "since", "", "only list bundles modified at or after this time (RFC3339 or YYYY-MM-DD)")
+	listCmd.Flags().StringVar(&listUntil, "until", "", "only list bundles modified before this time (RFC3339 or YYYY-MM-DD)")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "list at most this many bundles, after sorting (0 for all)")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "output format [table, json]")
+	listCmd.Flags().BoolVar(&listVersions, "versions", false, "list every version of each bundle in a versioned bucket")
 	rootCmd.AddCommand(listCmd)
 }
 
@@ -31,20 +83,100 @@ func listExports(cmd *cobra.Command, args []string) error {
 	if s3path == "" {
 		return fmt.Errorf("'path' is a required argument but not found")
 	}
-	if len(s3path) < 5 || s3path[:5] != "s3://" {
-		return errors.New("error: 'path' must begin with s3://")
+	if listOutput != "table" && listOutput != "json" {
+		return fmt.Errorf("error: unsupported output %q, we only accept: table, json", listOutput)
+	}
+	if listLimit < 0 {
+		return errors.New("error: --limit must not be negative")
+	}
+	less, err := listOrder(listSort)
+	if err != nil {
+		return err
+	}
+	since, err := parseListTime(listSince)
+	if err != nil {
+		return fmt.Errorf("error: --since: %w", err)
+	}
+	until, err := parseListTime(listUntil)
+	if err != nil {
+		return fmt.Errorf("error: --until: %w", err)
 	}
 
-	results, err := aws.S3List(s3path, "."+cryptExt)
+	if err := storage.Listable(s3path); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	store, prefix, err := storage.Open(s3path)
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	var objects []storage.Object
+	if listVersions {
+		objects, err = storage.ListVersions(s3path)
+	} else {
+		objects, err = store.List(prefix)
+	}
 	if err != nil {
 		return err
 	}
+	results := make([]storage.Object, 0, len(objects))
+	for _, obj := range objects {
+		if !isUploadKey(obj.Key) {
+			continue
+		}
+		if !since.IsZero() && obj.LastModified.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !obj.LastModified.Before(until) {
+			continue
+		}
+		results = append(results, obj)
+	}
+
+	sort.SliceStable(results, func(ii, jj int) bool {
+		if listReverse {
+			return less(results[jj], results[ii])
+		}
+		return less(results[ii], results[jj])
+	})
+	if listLimit > 0 && len(results) > listLimit {
+		results = results[:listLimit]
+	}
+
+	entries := make([]listEntry, len(results))
+	for ii, vv := range results {
+		entries[ii] = listEntry{
+			Key:          vv.Key,
+			Location:     store.URL(vv.Key),
+			Size:         vv.Size,
+			LastModified: vv.LastModified.UTC(),
+			StorageClass: vv.StorageClass,
+			ETag:         vv.ETag,
+			VersionID:    vv.VersionID,
+			IsLatest:     vv.IsLatest,
+			DeleteMarker: vv.DeleteMarker,
+		}
+		if vv.DeleteMarker {
+			continue
+		}
+		if listCompression {
+			entries[ii].Compression = bundleCompression(entries[ii].Location, vv.VersionID)
+		}
+		if listManifest {
+			entries[ii].Manifest = bundleManifest(entries[ii].Location, vv.VersionID)
+		}
+	}
+
+	if listOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
 
 	// We're using tabwriter to align arbitrary-width columns, but it can't handle mixing left- and
 	// right-aligned columns, so the size column, where we can set a reasonable maximum (<1TB), is
 	// explicitly right-aligned before printing.
 
-	if len(results) == 0 {
+	if len(entries) == 0 {
 		fmt.Printf("No results found at %s\nDone\n", s3path)
 
 		return nil
@@ -53,14 +185,53 @@ func listExports(cmd *cobra.Command, args []string) error {
 	msg := message.NewPrinter(message.MatchLanguage("en"))
 	tab := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
 
-	sizeStr := fmt.Sprintf("%15s", "Bytes")
-	fmt.Fprintf(tab, "Filename\t%s\t\n", sizeStr)
-	sizeStr = fmt.Sprintf("%15s", "---")
-
-	fmt.Fprintf(tab, "---\t%s\t\n", sizeStr)
+	header := []string{"Filename", fmt.Sprintf("%15s", "Bytes"), "Modified", "Class"}
+	if listVersions {
+		header = append(header, "Version", "Latest")
+	}
+	if listCompression {
+		header = append(header, "Compression")
+	}
+	if listManifest {
+		header = append(header, "Taken", "Vault", "Secrets", "Manifest")
+	}
+	fmt.Fprintf(tab, "%s\t\n", strings.Join(header, "\t"))
+	for ii := range header {
+		header[ii] = "---"
+	}
+	header[1] = fmt.Sprintf("%15s", "---")
+	fmt.Fprintf(tab, "%s\t\n", strings.Join(header, "\t"))
 
-	for _, vv := range results {
-		fmt.Fprintf(tab, "%s\t%s\t\n", vv.Key, msg.Sprintf("%15d", vv.Size))
+	for _, vv := range entries {
+		row := []string{vv.Key, msg.Sprintf("%15d", vv.Size), "-", orDash(vv.StorageClass)}
+		if !vv.LastModified.IsZero() {
+			row[2] = vv.LastModified.Format("2006-01-02 15:04:05")
+		}
+		if listVersions {
+			latest := ""
+			if vv.IsLatest {
+				latest = "*"
+			}
+			if vv.DeleteMarker {
+				row[1] = fmt.Sprintf("%15s", "deleted")
+			}
+			row = append(row, vv.VersionID, orDash(latest))
+		}
+		if vv.DeleteMarker {
+			fmt.Fprintf(tab, "%s\t\n", strings.Join(row, "\t"))
+			continue
+		}
+		if listCompression {
+			row = append(row, vv.Compression)
+		}
+		if listManifest {
+			mm := vv.Manifest
+			row = append(row, orDash(mm.Taken), orDash(mm.Vault), "-", mm.Status)
+			if mm.Status == "verified" || mm.Status == "unsigned" {
+				row[len(row)-2] = strconv.Itoa(mm.Secrets)
+			}
+		}
+		fmt.Fprintf(tab, "%s\t\n", strings.Join(row, "\t"))
 	}
 
 	tab.Flush()
@@ -68,3 +239,81 @@ func listExports(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// listOrder returns the comparison used by list --sort
+func listOrder(key string) (func(aa, bb storage.Object) bool, error) {
+	switch key {
+	case "key":
+		return func(aa, bb storage.Object) bool { return aa.Key < bb.Key }, nil
+	case "size":
+		return func(aa, bb storage.Object) bool { return aa.Size < bb.Size }, nil
+	case "time":
+		return func(aa, bb storage.Object) bool { return aa.LastModified.Before(bb.LastModified) }, nil
+	}
+	return nil, fmt.Errorf("error: unsupported sort %q, we only accept: key, size, time", key)
+}
+
+// parseListTime accepts an RFC3339 time or a UTC date, an empty value is the
+// zero time
+func parseListTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if tt, err := time.Parse(time.RFC3339, value); err == nil {
+		return tt, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// bundleCompression reads only the header of version of the bundle at location
+// and returns its compression algorithm
+func bundleCompression(location string, version string) string {
+	if !strings.HasSuffix(location, "."+cryptExt) {
+		// sealed and unencrypted uploads are stored as dumped
+		return "none"
+	}
+	body, err := storage.GetHead(location, version, bundleInfoSize)
+	if err != nil {
+		return "error"
+	}
+	defer body.Close()
+
+	info, err := bundle.ReadInfo(body)
+	if err != nil {
+		return "unknown"
+	}
+	if info.Compression == "" {
+		return "none"
+	}
+	return info.Compression
+}
+
+// bundleManifest returns the time taken, vault address, secret count and
+// signature status recorded in the manifest of version of the bundle at
+// location
+func bundleManifest(location string, version string) *listManifestInfo {
+	mm, err := readManifestVersion(location, version)
+	if errors.Is(err, errNoManifest) {
+		return &listManifestInfo{Status: "none"}
+	}
+	if err != nil {
+		return &listManifestInfo{Status: "invalid"}
+	}
+	if mm == nil {
+		return &listManifestInfo{Status: "none"}
+	}
+	status := "verified"
+	if mm.Signature == nil {
+		status = "unsigned"
+	} else if !manifestKeyConfigured() {
+		status = "unverified"
+	}
+	return &listManifestInfo{Taken: mm.CompletedAt, Vault: mm.VaultAddr, Secrets: mm.SecretCount, Status: status}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/lock"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	lockTimeoutFlag = "lock-timeout"
+	lockTTLFlag     = "lock-ttl"
+
+	// minLockTTL leaves a renewal, a third of the TTL, time to reach the
+	// storage before the lock expires
+	minLockTTL = 10 * time.Second
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Use:   "force-unlock [flags] <scheme>://<location>/[prefix]",
+		Short: "Remove the lock of a prefix left by a run that did not finish",
+		Long: `Remove the lock of a prefix left by a run that did not finish
+
+dump, prune and rekey hold a lock object in the prefix they write to, so
+overlapping runs do not interfere. A lock left by a run that was killed
+expires on its own after --lock-ttl; force-unlock removes it at once. Make
+sure its holder, shown before it is removed, is no longer running.
+
+A lock only covers the prefix it is in, not the prefixes below it that have
+locks of their own.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         doForceUnlock,
+		SilenceUsage: true,
+	}
+	rootCmd.AddCommand(Cmd)
+}
+
+// addLockFlags adds the flags controlling the lock of a command that writes
+// to a prefix
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration(lockTimeoutFlag, 0, "how long to wait for another run to release the lock of the prefix, such as 10m (default fail at once)")
+	cmd.Flags().Duration(lockTTLFlag, lock.DefaultTTL, "how long the lock lasts if this run dies without releasing it, at least "+minLockTTL.String())
+}
+
+// lockLocation returns the lock object of prefix, which is taken as a
+// directory with or without a trailing slash, so every command given the
+// same prefix shares the same lock
+func lockLocation(prefix string) (string, error) {
+	store, key, err := storage.Open(prefix)
+	if err != nil {
+		return "", fmt.Errorf("error: %w", err)
+	}
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	return store.URL(key + lock.Name), nil
+}
+
+// acquireLock takes the lock of prefix for cmd with the lock flags, or their
+// config keys. The flags are bound when the command runs as several commands
+// share the config keys.
+func acquireLock(cmd *cobra.Command, prefix string) (*lock.Lock, error) {
+	for _, name := range []string{lockTimeoutFlag, lockTTLFlag} {
+		viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+	if ttl := viper.GetDuration(lockTTLFlag); ttl < minLockTTL {
+		return nil, fmt.Errorf("error: --%s must be at least %s, the lock is renewed every third of it", lockTTLFlag, minLockTTL)
+	}
+	location, err := lockLocation(prefix)
+	if err != nil {
+		return nil, err
+	}
+	lk, err := lock.Acquire(location, &lock.Options{
+		Command: cmd.Name(),
+		TTL:     viper.GetDuration(lockTTLFlag),
+		Timeout: viper.GetDuration(lockTimeoutFlag),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return lk, nil
+}
+
+func doForceUnlock(cmd *cobra.Command, args []string) error {
+	location, err := lockLocation(args[0])
+	if err != nil {
+		return err
+	}
+	info, err := lock.Read(location)
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	state := "held"
+	if time.Now().After(info.ExpiresAt) {
+		state = "expired"
+	}
+	fmt.Printf("Removing %s lock %s of %s\n", state, location, info)
+	if err := storage.Delete(location); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/viper"
+)
+
+// errNoManifest is returned for bundles without a manifest when a manifest
+// key is configured
+var errNoManifest = errors.New("no manifest, one signed with the manifest key is required")
+
+// errNoSigner is returned for changes to a signed manifest that could not be
+// signed again
+var errNoSigner = errors.New("manifest is signed and no manifest key is given to sign it again")
+
+const (
+	manifestHMACKeyFlag = "manifest-hmac-key"
+	manifestKMSKeyFlag  = "manifest-kms-key"
+)
+
+// manifestSigner returns the signer configured with --manifest-kms-key or
+// --manifest-hmac-key, or nil when manifests are left unsigned
+func manifestSigner() manifest.Signer {
+	if key := viper.GetString(manifestKMSKeyFlag); key != "" {
+		return aws.NewKMSSigner(key)
+	}
+	if key := viper.GetString(manifestHMACKeyFlag); key != "" {
+		return &manifest.HMACKey{Key: []byte(key)}
+	}
+	return nil
+}
+
+// manifestKeyConfigured reports whether a key to verify manifests is given,
+// in which case every bundle must have a manifest signed with it
+func manifestKeyConfigured() bool {
+	return viper.GetString(manifestKMSKeyFlag) != "" || viper.GetString(manifestHMACKeyFlag) != ""
+}
+
+// resolveSignature returns the verifier for a manifest signature, which
+// checks it against the key given with --manifest-kms-key or
+// --manifest-hmac-key only, never the key named in the manifest
+func resolveSignature(sig *manifest.Signature) (manifest.Verifier, error) {
+	if sig.Algorithm == manifest.HMACSHA256 {
+		key := viper.GetString(manifestHMACKeyFlag)
+		if key == "" {
+			return nil, fmt.Errorf("manifest is signed with an HMAC key, give it with --%s", manifestHMACKeyFlag)
+		}
+		return &manifest.HMACKey{Key: []byte(key)}, nil
+	}
+	key := viper.GetString(manifestKMSKeyFlag)
+	if key == "" {
+		return nil, fmt.Errorf("manifest is signed with KMS key %s, give the key to verify it with --%s", sig.KeyID, manifestKMSKeyFlag)
+	}
+	return aws.NewKMSSigner(key), nil
+}
+
+// writeManifest signs mm and stores it next to the bundle at bundlePath.
+// Without a signer any signature mm had is dropped, as it would not match.
+func writeManifest(bundlePath string, mm *manifest.Manifest) error {
+	if signer := manifestSigner(); signer != nil {
+		if err := mm.Sign(signer); err != nil {
+			return fmt.Errorf("error signing manifest: %w", err)
+		}
+	} else {
+		mm.Signature = nil
+		log.Printf("Manifest is not signed, use --%s or --%s to sign it", manifestKMSKeyFlag, manifestHMACKeyFlag)
+	}
+
+	data, err := mm.Encode()
+	if err != nil {
+		return err
+	}
+	return storage.WriteAll(storage.WithExt(bundlePath, manifest.Ext), data)
+}
+
+// requireSigner returns an error when the manifest mm of the bundle at
+// bundlePath is signed but no signer is configured to sign it again once
+// changed, so callers can refuse before writing anything
+func requireSigner(bundlePath string, mm *manifest.Manifest) error {
+	if mm != nil && mm.Signature != nil && manifestSigner() == nil {
+		return fmt.Errorf("error: %s: %w, give --%s or --%s", bundlePath, errNoSigner, manifestKMSKeyFlag, manifestHMACKeyFlag)
+	}
+	return nil
+}
+
+// readManifest returns the manifest stored next to the bundle at
+// bundlePath, or nil when there is none. With a manifest key configured the
+// manifest must exist and verify with it, otherwise its signature cannot be
+// trusted and is not checked.
+func readManifest(bundlePath string) (*manifest.Manifest, error) {
+	return readManifestVersion(bundlePath, "")
+}
+
+// readManifestVersion is readManifest for version of the bundle, the
+// manifest version written along with it is used
+func readManifestVersion(bundlePath string, version string) (*manifest.Manifest, error) {
+	manifestVersion := ""
+	if version != "" {
+		bundles, err := storage.ListVersions(bundlePath)
+		if err != nil {
+			return nil, err
+		}
+		manifests, err := storage.ListVersions(storage.WithExt(bundlePath, manifest.Ext))
+		if err != nil {
+			return nil, err
+		}
+		match, err := storage.CompanionVersion(exactVersions(bundles, bundlePath), exactVersions(manifests, storage.WithExt(bundlePath, manifest.Ext)), version)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			return missingManifest(bundlePath)
+		}
+		manifestVersion = match.VersionID
+	}
+
+	body, err := storage.GetVersion(storage.WithExt(bundlePath, manifest.Ext), manifestVersion)
+	if errors.Is(err, storage.ErrNotFound) {
+		return missingManifest(bundlePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	mm, err := manifest.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if !manifestKeyConfigured() {
+		log.Printf("Manifest of %s is not verified, give --%s or --%s to check its signature", storage.Redact(bundlePath), manifestKMSKeyFlag, manifestHMACKeyFlag)
+		return mm, nil
+	}
+	if mm.Signature == nil {
+		return nil, fmt.Errorf("error: %s is not signed", storage.Redact(storage.WithExt(bundlePath, manifest.Ext)))
+	}
+	if err := mm.Verify(resolveSignature); err != nil {
+		return nil, fmt.Errorf("error: %s: %w", storage.Redact(storage.WithExt(bundlePath, manifest.Ext)), err)
+	}
+	return mm, nil
+}
+
+// missingManifest is the result of reading the manifest of a bundle that has
+// none, an error when manifests are required
+func missingManifest(bundlePath string) (*manifest.Manifest, error) {
+	if manifestKeyConfigured() {
+		return nil, fmt.Errorf("error: %s: %w", bundlePath, errNoManifest)
+	}
+	return nil, nil
+}
+
+// exactVersions keeps the versions of the object at location, dropping those
+// of other keys sharing it as a prefix
+func exactVersions(versions []storage.Object, location string) []storage.Object {
+	store, key, err := storage.Open(location)
+	if err != nil {
+		return nil
+	}
+	exact := []storage.Object{}
+	for _, vv := range versions {
+		if store.URL(vv.Key) == store.URL(key) {
+			exact = append(exact, vv)
+		}
+	}
+	return exact
+}
+
+// manifestObject returns the object key recorded in the manifest of the
+// bundle at location: the key in its bucket or host without any query string,
+// or the absolute path of local files
+func manifestObject(location string) string {
+	_, key, err := storage.Open(location)
+	if err != nil {
+		return ""
+	}
+	switch storage.Scheme(location) {
+	case "", "file":
+		if abs, err := filepath.Abs(key); err == nil {
+			key = abs
+		}
+	case "http", "https":
+		// presigned URLs carry a signature that changes on every request
+		if idx := strings.Index(key, "?"); idx >= 0 {
+			key = key[:idx]
+		}
+	}
+	return key
+}
+
+// checkManifest compares the checksums of a bundle at location read in full
+// with those recorded in its manifest, the plaintext of unencrypted uploads
+// is the object itself. The manifest must be the one written for location,
+// so an older bundle copied over it along with its manifest is refused.
+func checkManifest(mm *manifest.Manifest, location string, object *manifest.Hasher, plaintext *manifest.Hasher) error {
+	if want := manifestObject(location); mm.Object != want {
+		return fmt.Errorf("manifest is for the object %q, not %q", mm.Object, want)
+	}
+	if err := manifest.Check("object", mm.Ciphertext, object.Sum()); err != nil {
+		return err
+	}
+	if mm.Encrypted {
+		return manifest.Check("plaintext", mm.Plaintext, plaintext.Sum())
+	}
+	return nil
+}
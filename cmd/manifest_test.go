@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+)
+
+func TestSuiteCheckManifest(tt *testing.T) {
+
+	var (
+		err     error
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			isSuccess   bool
+		}{
+			{"Same object", "Check", []string{"s3://bk/prod/a.json.aes", "s3://bk/prod/a.json.aes", "data"}, true},
+			{"Same object in another bucket", "Check", []string{"s3://bk/prod/a.json.aes", "s3://replica/prod/a.json.aes", "data"}, true},
+			{"Older object copied over", "Check", []string{"s3://bk/prod/a.json.aes", "s3://bk/prod/b.json.aes", "data"}, false},
+			{"No object recorded", "Check", []string{"", "s3://bk/prod/a.json.aes", "data"}, false},
+			{"Presigned URL", "Check", []string{"https://host/prod/a.json.aes?X-Amz-Signature=1", "https://host/prod/a.json.aes?X-Amz-Signature=2", "data"}, true},
+			{"Relative path", "Check", []string{"prod/a.json.aes", "./prod/a.json.aes", "data"}, true},
+			{"Object checksum mismatch", "Check", []string{"s3://bk/prod/a.json.aes", "s3://bk/prod/a.json.aes", "other"}, false},
+		}
+	)
+
+	for _, test := range tests {
+		switch test.action {
+		case "Check":
+			recorded := manifest.NewHasher()
+			recorded.Write([]byte("data"))
+			mm := manifest.New("test")
+			mm.Ciphertext = recorded.Sum()
+			if test.inputs[0] != "" {
+				mm.Object = manifestObject(test.inputs[0])
+			}
+			object := manifest.NewHasher()
+			object.Write([]byte(test.inputs[2]))
+			err = checkManifest(mm, test.inputs[1], object, manifest.NewHasher())
+			success = (err == nil)
+		}
+
+		if success == test.isSuccess {
+			tt.Logf("PASS %s", test.description)
+		} else {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/lock"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/retention"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneCmd        *cobra.Command
+	prunePolicy     retention.Policy
+	pruneDryRun     bool
+	pruneTimeSource string
+)
+
+func init() {
+	pruneCmd = &cobra.Command{
+		Use:   "prune [flags] <scheme>://<location>/[prefix]",
+		Short: "Deletes old vault state files outside the retention policy",
+		Long: `Deletes old vault state files outside the retention policy
+
+The newest backup of each of the last --keep-hourly hours, --keep-daily days,
+--keep-weekly weeks and --keep-monthly months is kept, every other bundle
+under the prefix is deleted along with its manifest. The newest backup with a
+valid manifest is never deleted.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         pruneExports,
+		SilenceUsage: true,
+	}
+	pruneCmd.Flags().IntVar(&prunePolicy.Hourly, "keep-hourly", 0, "number of hourly backups to keep")
+	pruneCmd.Flags().IntVar(&prunePolicy.Daily, "keep-daily", 0, "number of daily backups to keep")
+	pruneCmd.Flags().IntVar(&prunePolicy.Weekly, "keep-weekly", 0, "number of weekly backups to keep")
+	pruneCmd.Flags().IntVar(&prunePolicy.Monthly, "keep-monthly", 0, "number of monthly backups to keep")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "print what would be deleted without deleting it")
+	pruneCmd.Flags().StringVar(&pruneTimeSource, "time-source", "object", "timestamp to apply the policy to [object, manifest]")
+	addLockFlags(pruneCmd)
+	rootCmd.AddCommand(pruneCmd)
+}
+
+func pruneExports(cmd *cobra.Command, args []string) (err error) {
+	if prunePolicy.Empty() {
+		return errors.New("error: give at least one of --keep-hourly, --keep-daily, --keep-weekly or --keep-monthly")
+	}
+	if pruneTimeSource != "object" && pruneTimeSource != "manifest" {
+		return fmt.Errorf("error: unsupported time source %q, we only accept: object, manifest", pruneTimeSource)
+	}
+
+	if err := storage.Listable(args[0]); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	store, prefix, err := storage.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	var lk *lock.Lock
+	if !pruneDryRun {
+		var lockErr error
+		lk, lockErr = acquireLock(cmd, args[0])
+		if lockErr != nil {
+			return lockErr
+		}
+		defer func() {
+			if unlockErr := lk.Release(); unlockErr != nil && err == nil {
+				err = unlockErr
+			}
+		}()
+	}
+	objects, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	backups := []retention.Backup{}
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, "."+cryptExt) {
+			continue
+		}
+		backups = append(backups, pruneBackup(store.URL(obj.Key), obj))
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups found at %s\n", args[0])
+		return nil
+	}
+
+	failed := 0
+	deleted := 0
+	for _, dd := range retention.Apply(prunePolicy, backups) {
+		when := dd.Time.UTC().Format(time.RFC3339)
+		if dd.Keep {
+			fmt.Printf("%-12s  %s  %s  (%s)\n", "keep", when, dd.Key, strings.Join(dd.Reasons, ", "))
+			continue
+		}
+		if pruneDryRun {
+			fmt.Printf("%-12s  %s  %s\n", "would delete", when, dd.Key)
+			continue
+		}
+		if err := lk.Lost(); err != nil {
+			return err
+		}
+		if err := pruneBundle(dd.Key); err != nil {
+			log.Printf("Failed to delete %s: %s", dd.Key, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%-12s  %s  %s\n", "deleted", when, dd.Key)
+		deleted++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("error: failed to delete %d of %d backups", failed, failed+deleted)
+	}
+	return nil
+}
+
+// pruneBackup describes the bundle at location for the retention policy, a
+// bundle is successful when its manifest reads and verifies
+func pruneBackup(location string, obj storage.Object) retention.Backup {
+	bb := retention.Backup{Key: location, Time: obj.LastModified}
+
+	mm, err := readManifest(location)
+	if err != nil {
+		log.Printf("Manifest of %s is invalid: %s", location, err)
+		return bb
+	}
+	if mm == nil {
+		return bb
+	}
+	bb.Successful = true
+	if pruneTimeSource == "manifest" {
+		if completed, err := time.Parse(time.RFC3339, mm.CompletedAt); err == nil {
+			bb.Time = completed
+		} else {
+			log.Printf("Manifest of %s has no completion time, using the object time", location)
+		}
+	}
+	return bb
+}
+
+// pruneBundle deletes the bundle at location and then its manifest
+func pruneBundle(location string) error {
+	if err := storage.Delete(location); err != nil {
+		return err
+	}
+	err := storage.Delete(storage.WithExt(location, manifest.Ext))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
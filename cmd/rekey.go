@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/lock"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+const (
+	rekeyDone    = "done"
+	rekeyWritten = "written" // bundle re-keyed, manifest not yet updated
+)
+
+var (
+	rekeyKMSKeys     []string
+	rekeyConcurrency int
+	rekeyDryRun      bool
+	rekeyProgress    string
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Use:   "rekey [flags] <scheme>://<location>/[prefix]",
+		Short: "Wrap the data key of every bundle under a prefix for new KMS keys",
+		Long: `Wrap the data key of every bundle under a prefix for new KMS keys
+
+The data key of each bundle is unwrapped with the keys it is currently
+encrypted for and wrapped again for every --new-kms-key, which replace them.
+The encrypted payload is copied unchanged and checked on the way; bundles
+written before the current format bind the key to the payload and are
+decrypted and encrypted again instead. Manifests are updated with the new
+object checksum and signed again with --manifest-kms-key or
+--manifest-hmac-key; bundles with a signed manifest fail without either.
+
+Bundles are re-keyed concurrently. Every bundle done is recorded in the
+--progress file, so an interrupted run can be started again and skips them.
+
+Versioned buckets keep the previous versions of each bundle, which can still
+be decrypted with the old keys until they expire.`,
+		Args:         cobra.ExactArgs(1),
+		RunE:         doRekey,
+		SilenceUsage: true,
+	}
+	Cmd.Flags().StringSliceVar(&rekeyKMSKeys, "new-kms-key", []string{}, "KMS key ARN to wrap data keys for, may be repeated")
+	Cmd.Flags().IntVar(&rekeyConcurrency, "concurrency", 4, "number of bundles re-keyed at once")
+	Cmd.Flags().BoolVar(&rekeyDryRun, "dry-run", false, "print what would be re-keyed without changing it")
+	Cmd.Flags().StringVar(&rekeyProgress, "progress", "vault-dump-rekey.progress", "file recording the bundles done, to resume an interrupted run")
+	addS3PutFlags(Cmd)
+	addLockFlags(Cmd)
+	rootCmd.AddCommand(Cmd)
+}
+
+// rekeyRecord is one line of the progress file
+type rekeyRecord struct {
+	Object     string            `json:"object"`
+	KMSKeys    []string          `json:"kms_keys"`
+	Status     string            `json:"status"`
+	Ciphertext manifest.Checksum `json:"ciphertext"`
+	Time       string            `json:"time"`
+}
+
+// rekeyLog appends records to the progress file
+type rekeyLog struct {
+	mu   sync.Mutex
+	file *os.File
+	keys []string
+}
+
+func doRekey(cmd *cobra.Command, args []string) (err error) {
+	if len(rekeyKMSKeys) == 0 {
+		return errors.New("error: give at least one --new-kms-key")
+	}
+	if rekeyConcurrency < 1 {
+		return errors.New("error: --concurrency must be at least 1")
+	}
+	if err := applyS3PutOptions(cmd); err != nil {
+		return err
+	}
+	if err := storage.Listable(args[0]); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+
+	store, prefix, err := storage.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	objects, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+	locations := []string{}
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "."+cryptExt) {
+			locations = append(locations, store.URL(obj.Key))
+		}
+	}
+	if len(locations) == 0 {
+		fmt.Printf("No bundles found at %s\n", args[0])
+		return nil
+	}
+
+	progress, err := readRekeyProgress(rekeyProgress, rekeyKMSKeys)
+	if err != nil {
+		return err
+	}
+	var (
+		plog *rekeyLog
+		lk   *lock.Lock
+	)
+	if !rekeyDryRun {
+		var lockErr error
+		lk, lockErr = acquireLock(cmd, args[0])
+		if lockErr != nil {
+			return lockErr
+		}
+		defer func() {
+			if unlockErr := lk.Release(); unlockErr != nil && err == nil {
+				err = unlockErr
+			}
+		}()
+
+		ff, err := os.OpenFile(rekeyProgress, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer ff.Close()
+		plog = &rekeyLog{file: ff, keys: rekeyKMSKeys}
+	}
+
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+		jobs   = make(chan string)
+	)
+	for ii := 0; ii < rekeyConcurrency; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for location := range jobs {
+				var (
+					status, detail string
+					err            error
+				)
+				rec := progress[location]
+				switch {
+				case rec != nil && rec.Status == rekeyDone:
+					status, detail = "skip", "done by a previous run"
+				case plog == nil:
+					status, detail, err = rekeyPlan(location)
+				default:
+					if err = lk.Lost(); err == nil {
+						status, err = rekeyBundle(location, rec, plog)
+					}
+				}
+				if err != nil {
+					status, detail = "failed", err.Error()
+				}
+				mu.Lock()
+				if err != nil {
+					failed++
+				}
+				if detail != "" {
+					fmt.Printf("%-12s  %s  (%s)\n", status, location, detail)
+				} else {
+					fmt.Printf("%-12s  %s\n", status, location)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, location := range locations {
+		jobs <- location
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("error: %d of %d bundles failed to rekey, run again to retry them", failed, len(locations))
+	}
+	return nil
+}
+
+// rekeyBundle re-keys the bundle at location and updates its manifest,
+// returning what was done. rec is the last progress record of the bundle.
+func rekeyBundle(location string, rec *rekeyRecord, plog *rekeyLog) (string, error) {
+	mm, err := readManifest(location)
+	if err != nil {
+		return "", err
+	}
+	if err := requireSigner(location, mm); err != nil {
+		return "", err
+	}
+
+	src, err := storage.Get(location)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := storage.Put(location)
+	if err != nil {
+		return "", err
+	}
+
+	before := manifest.NewHasher()
+	after := manifest.NewHasher()
+	key, value := objectContext(location)
+	legacyContext, err := aws.KMSEncryptionContext(map[string]string{key: value})
+	if err != nil {
+		dst.Abort()
+		return "", err
+	}
+	reencrypted, err := bundle.Rekey(before.TeeReader(src), io.MultiWriter(dst, after), resolveProvider, &bundle.RekeyOptions{
+		Recipients: func(context map[string]string) ([]bundle.Provider, error) {
+			return aws.KMSProviders(rekeyKMSKeys, context), nil
+		},
+		LegacyContext: legacyContext,
+	})
+	if err != nil {
+		dst.Abort()
+		return "", err
+	}
+
+	if mm != nil {
+		if err := manifest.Check("object", mm.Ciphertext, before.Sum()); err != nil {
+			dst.Abort()
+			if rec == nil || rec.Status != rekeyWritten || rec.Ciphertext != before.Sum() {
+				return "", err
+			}
+			// re-keyed by an interrupted run, only the manifest is left
+			mm.Ciphertext = rec.Ciphertext
+			if err := writeManifest(location, mm); err != nil {
+				return "", err
+			}
+			return "rekeyed", plog.record(location, rekeyDone, rec.Ciphertext)
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	sum := after.Sum()
+	if mm != nil {
+		if err := plog.record(location, rekeyWritten, sum); err != nil {
+			return "", err
+		}
+		mm.Ciphertext = sum
+		if err := writeManifest(location, mm); err != nil {
+			return "", fmt.Errorf("bundle re-keyed but its manifest was not updated, run again to retry: %w", err)
+		}
+	}
+	if err := plog.record(location, rekeyDone, sum); err != nil {
+		return "", err
+	}
+	if reencrypted {
+		return "reencrypted", nil
+	}
+	return "rekeyed", nil
+}
+
+// rekeyPlan reads the header of the bundle at location to tell what a run
+// would do and the recipients it is encrypted for, without decrypting it
+func rekeyPlan(location string) (string, string, error) {
+	src, err := storage.Get(location)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+	info, err := bundle.ReadInfo(src)
+	if err != nil {
+		return "", "", err
+	}
+	current := make([]string, 0, len(info.Recipients))
+	for _, stanza := range info.Recipients {
+		current = append(current, stanza.String())
+	}
+	action := "rekey"
+	if info.Version < 3 {
+		action = "reencrypt"
+	}
+	return action, "from " + strings.Join(current, ", "), nil
+}
+
+// readRekeyProgress returns the last record of every bundle in the progress
+// file at path made for the same keys, a missing file has none
+func readRekeyProgress(path string, keys []string) (map[string]*rekeyRecord, error) {
+	progress := map[string]*rekeyRecord{}
+	ff, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+
+	scanner := bufio.NewScanner(ff)
+	for line := 1; scanner.Scan(); line++ {
+		rec := &rekeyRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			// a run killed while writing may leave a partial last line
+			log.Printf("Ignoring line %d of %s: %s", line, path, err)
+			continue
+		}
+		if sameKeys(rec.KMSKeys, keys) {
+			progress[rec.Object] = rec
+		}
+	}
+	return progress, scanner.Err()
+}
+
+// record appends a record for the bundle at location, written whole so a
+// concurrent or interrupted run never leaves a partial line
+func (l *rekeyLog) record(location string, status string, sum manifest.Checksum) error {
+	line, err := json.Marshal(&rekeyRecord{
+		Object:     location,
+		KMSKeys:    l.keys,
+		Status:     status,
+		Ciphertext: sum,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// sameKeys reports whether aa and bb hold the same keys in any order
+func sameKeys(aa []string, bb []string) bool {
+	if len(aa) != len(bb) {
+		return false
+	}
+	as := append([]string{}, aa...)
+	bs := append([]string{}, bb...)
+	sort.Strings(as)
+	sort.Strings(bs)
+	for ii := range as {
+		if as[ii] != bs[ii] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/dump"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+	"github.com/spf13/cobra"
+)
+
+const (
+	replicateToFlag     = "replicate-to"
+	replicateKMSKeyFlag = "replicate-kms-key"
+	replicateRegionFlag = "replicate-region"
+)
+
+var (
+	replicateKMSKeys map[string]string
+	replicateRegions map[string]string
+	replicateForce   bool
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Use:   "replicate [flags] <scheme>://<source>/[prefix] <scheme>://<destination>/[prefix]...",
+		Short: "Copy bundles and their manifests to secondary destinations",
+		Long: `Copy bundles and their manifests to secondary destinations
+
+Every bundle under the source prefix is copied to each destination along with
+its manifest, keeping its name below the prefix. The source is checked
+against its manifest, and each copy is read back and its checksum compared
+with what was written.
+
+With --replicate-kms-key, the data key of the copies sent to a destination is
+wrapped for that key instead, such as a key in the destination region, so the
+replica does not depend on the keys of the primary region.
+
+A manifest records the object it was written for, so the manifest of a copy
+under another key, or of a re-wrapped copy, records its own object and
+checksum and is signed again with --manifest-kms-key or --manifest-hmac-key,
+which are required when the original manifest is signed. Copies to the same
+key keep the original manifest and signature.
+
+Bundles already at a destination with the same plaintext checksum in their
+manifest are skipped, unless --force is given. The command exits non-zero if
+any copy fails.`,
+		Args:         cobra.MinimumNArgs(2),
+		RunE:         doReplicate,
+		SilenceUsage: true,
+	}
+	Cmd.Flags().StringToStringVar(&replicateKMSKeys, replicateKMSKeyFlag, map[string]string{}, "KMS key ARN to wrap data keys for at a destination, destination=arn[,...]")
+	Cmd.Flags().StringToStringVar(&replicateRegions, replicateRegionFlag, map[string]string{}, "AWS region of the bucket of an S3 destination, destination=region[,...]")
+	Cmd.Flags().BoolVar(&replicateForce, "force", false, "copy bundles already present at a destination")
+	addS3PutFlags(Cmd)
+	rootCmd.AddCommand(Cmd)
+}
+
+// replica is a destination bundles are copied to
+type replica struct {
+	Location string
+	KMSKey   string
+}
+
+// replicas returns the destinations given as locations, with the keys and
+// regions given for them by destination. Destinations are expanded with data
+// unless it is nil, the keys and regions name them as given.
+func replicas(locations []string, kmsKeys map[string]string, regions map[string]string, data *dump.NameData) ([]replica, error) {
+	known := map[string]bool{}
+	targets := make([]replica, 0, len(locations))
+	for _, location := range locations {
+		location = vault.EnsureNoTrailingSlash(location)
+		known[location] = true
+		target := replica{Location: location, KMSKey: lookupReplica(kmsKeys, location)}
+		if data != nil {
+			expanded, err := dump.ExpandName(location, data)
+			if err != nil {
+				return nil, fmt.Errorf("error: --%s: %w", replicateToFlag, err)
+			}
+			target.Location = expanded
+		}
+		if _, _, err := storage.Open(target.Location); err != nil {
+			return nil, fmt.Errorf("error: %w", err)
+		}
+		if region := lookupReplica(regions, location); region != "" {
+			if storage.Scheme(target.Location) != "s3" {
+				return nil, fmt.Errorf("error: --%s: %s is not an S3 destination", replicateRegionFlag, location)
+			}
+			bucket, _ := aws.ParseS3Path(target.Location)
+			aws.SetBucketRegion(bucket, region)
+		}
+		targets = append(targets, target)
+	}
+
+	for _, pairs := range []map[string]string{kmsKeys, regions} {
+		for location := range pairs {
+			if !known[vault.EnsureNoTrailingSlash(location)] {
+				return nil, fmt.Errorf("error: %s is not a replica destination", location)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// lookupReplica returns the value given for location, which may have been
+// written with a trailing slash
+func lookupReplica(pairs map[string]string, location string) string {
+	for kk, vv := range pairs {
+		if vault.EnsureNoTrailingSlash(kk) == location {
+			return vv
+		}
+	}
+	return ""
+}
+
+func doReplicate(cmd *cobra.Command, args []string) error {
+	if err := applyS3PutOptions(cmd); err != nil {
+		return err
+	}
+	targets, err := replicas(args[1:], replicateKMSKeys, replicateRegions, nil)
+	if err != nil {
+		return err
+	}
+
+	store, prefix, err := storage.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	objects, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	copies, failed := 0, 0
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, "."+cryptExt) {
+			continue
+		}
+		name := strings.TrimLeft(strings.TrimPrefix(obj.Key, prefix), "/")
+		for _, target := range targets {
+			copies++
+			src, dst := store.URL(obj.Key), target.Location+"/"+name
+			status, err := replicateBundle(src, dst, target.KMSKey, replicateForce)
+			if err != nil {
+				failed++
+				fmt.Printf("%-12s  %s  (%s)\n", "failed", dst, err)
+				continue
+			}
+			fmt.Printf("%-12s  %s\n", status, dst)
+		}
+	}
+	if copies == 0 {
+		fmt.Printf("No bundles found at %s\n", args[0])
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("error: %d of %d copies failed", failed, copies)
+	}
+	return nil
+}
+
+// replicateBundle copies the bundle at src and its manifest to dst, with the
+// data key wrapped for kmsKey when given, and reads the copy back to check
+// it. Unless force is set, a bundle already at dst is left alone. It returns
+// what was done.
+func replicateBundle(src string, dst string, kmsKey string, force bool) (string, error) {
+	mm, err := readManifest(src)
+	if err != nil {
+		return "", err
+	}
+	if !force && mm != nil && replicaCurrent(dst, mm) {
+		return "skip", nil
+	}
+	rewrap := kmsKey != "" && (mm == nil || mm.Encrypted)
+	// the manifest names the object it was written for, a copy under another
+	// key gets its own
+	retarget := mm != nil && mm.Object != manifestObject(dst)
+	if rewrap || retarget {
+		// the manifest of the copy changes and must be signed again
+		if err := requireSigner(src, mm); err != nil {
+			return "", err
+		}
+	}
+
+	in, err := storage.Get(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := storage.Put(dst)
+	if err != nil {
+		return "", err
+	}
+
+	before := manifest.NewHasher()
+	after := manifest.NewHasher()
+	ww := io.MultiWriter(out, after)
+	if rewrap {
+		key, value := objectContext(dst)
+		legacyContext, err := aws.KMSEncryptionContext(map[string]string{key: value})
+		if err == nil {
+			_, err = bundle.Rekey(before.TeeReader(in), ww, resolveProvider, &bundle.RekeyOptions{
+				Recipients: func(context map[string]string) ([]bundle.Provider, error) {
+					return aws.KMSProviders([]string{kmsKey}, context), nil
+				},
+				LegacyContext: legacyContext,
+			})
+		}
+		if err != nil {
+			out.Abort()
+			return "", err
+		}
+	} else if _, err := io.Copy(ww, before.TeeReader(in)); err != nil {
+		out.Abort()
+		return "", err
+	}
+
+	if mm != nil {
+		if err := manifest.Check("object", mm.Ciphertext, before.Sum()); err != nil {
+			out.Abort()
+			return "", err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if err := checkReplica(dst, after.Sum()); err != nil {
+		return "", err
+	}
+
+	if mm == nil {
+		log.Printf("%s has no manifest, none replicated", storage.Redact(src))
+	} else if rewrap || retarget {
+		mm.Ciphertext = after.Sum()
+		mm.Object = manifestObject(dst)
+		if err := writeManifest(dst, mm); err != nil {
+			return "", fmt.Errorf("error writing manifest: %w", err)
+		}
+	} else {
+		// copied as is to the same key, so the original signature still holds
+		data, err := storage.ReadAll(storage.WithExt(src, manifest.Ext))
+		if err != nil {
+			return "", err
+		}
+		if err := storage.WriteAll(storage.WithExt(dst, manifest.Ext), data); err != nil {
+			return "", fmt.Errorf("error writing manifest: %w", err)
+		}
+	}
+
+	if rewrap {
+		return "rewrapped", nil
+	}
+	return "copied", nil
+}
+
+// replicaCurrent reports whether dst already holds a copy of the bundle
+// described by mm: its manifest records the same plaintext and the object
+// has the size recorded there
+func replicaCurrent(dst string, mm *manifest.Manifest) bool {
+	replica, err := readManifest(dst)
+	if err != nil || replica == nil || replica.Plaintext != mm.Plaintext {
+		return false
+	}
+	obj, err := storage.Stat(dst)
+	return err == nil && obj.Size == replica.Ciphertext.Size
+}
+
+// checkReplica reads back the object at dst and compares it with the
+// checksum of what was written
+func checkReplica(dst string, written manifest.Checksum) error {
+	body, err := storage.Get(dst)
+	if err != nil {
+		return fmt.Errorf("error reading back %s: %w", dst, err)
+	}
+	defer body.Close()
+	readBack := manifest.NewHasher()
+	if _, err := io.Copy(readBack, body); err != nil {
+		return fmt.Errorf("error reading back %s: %w", dst, err)
+	}
+	if err := manifest.Check("replica", written, readBack.Sum()); err != nil {
+		return fmt.Errorf("error: %s: %w", dst, err)
+	}
+	return nil
+}
+
+// replicateDump copies the bundle written by dump to every replica, the
+// bundle keeps its name. Every replica is attempted before failing.
+func replicateDump(bundlePath string, targets []replica) error {
+	name := bundlePath[strings.LastIndex(bundlePath, "/")+1:]
+	failed := 0
+	for _, target := range targets {
+		dst := target.Location + "/" + name
+		status, err := replicateBundle(bundlePath, dst, target.KMSKey, true)
+		if err != nil {
+			failed++
+			log.Printf("Failed to replicate %s to %s: %s", storage.Redact(bundlePath), storage.Redact(dst), err)
+			continue
+		}
+		log.Printf("Replicated %s to %s (%s)", storage.Redact(bundlePath), storage.Redact(dst), status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("error: backup stored at %s but %d of %d replicas failed", storage.Redact(bundlePath), failed, len(targets))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/viper"
+)
+
+// writeSignedBundle stores data at location with a manifest signed with the
+// HMAC key
+func writeSignedBundle(location string, data []byte, key string) error {
+	if err := storage.WriteAll(location, data); err != nil {
+		return err
+	}
+	sum := manifest.NewHasher()
+	sum.Write(data)
+	mm := manifest.New("test")
+	mm.Encrypted = true
+	mm.Ciphertext = sum.Sum()
+	mm.Object = manifestObject(location)
+	if err := mm.Sign(&manifest.HMACKey{Key: []byte(key)}); err != nil {
+		return err
+	}
+	encoded, err := mm.Encode()
+	if err != nil {
+		return err
+	}
+	return storage.WriteAll(storage.WithExt(location, manifest.Ext), encoded)
+}
+
+func TestSuiteReplicate(tt *testing.T) {
+
+	dir, err := ioutil.TempDir("", "replicate-test-*")
+	if err != nil {
+		tt.Fatalf("FAIL temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer viper.Set(manifestHMACKeyFlag, "")
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Rewrap signed manifest without signer", "Replicate", []string{"rewrap", "arn:replica", ""}, "no replica", false},
+			{"Copy signed manifest without signer", "Replicate", []string{"copy", "", ""}, "no replica", false},
+			{"Copy signed manifest with signer", "Replicate", []string{"signed", "", "k"}, "copied", true},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		src := filepath.Join(dir, "src", test.inputs[0], "bundle.json.aes")
+		dst := filepath.Join(dir, "dst", test.inputs[0], "bundle.json.aes")
+		if err := writeSignedBundle(src, []byte("This is a test!"), "k"); err != nil {
+			tt.Fatalf("FAIL %s: %s", test.description, err)
+		}
+
+		switch test.action {
+		case "Replicate":
+			viper.Set(manifestHMACKeyFlag, test.inputs[2])
+			var status string
+			status, err = replicateBundle(src, dst, test.inputs[1], true)
+			success = (err == nil)
+			if !success && !errors.Is(err, errNoSigner) {
+				tt.Errorf("FAIL %s: expected errNoSigner got %v", test.description, err)
+			}
+
+			// the replica is read back by a run with the manifest key, and its
+			// manifest must name it
+			viper.Set(manifestHMACKeyFlag, "k")
+			_, statErr := storage.Stat(dst)
+			replica, readErr := readManifest(dst)
+			switch {
+			case errors.Is(statErr, storage.ErrNotFound) && errors.Is(readErr, errNoManifest):
+				norm = "no replica"
+			case statErr == nil && readErr == nil && replica.Object == manifestObject(dst):
+				norm = status
+			default:
+				norm = "invalid replica"
+			}
+		}
+
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
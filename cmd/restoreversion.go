@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	Cmd := &cobra.Command{
+		Use:   "restore-version [flags] <scheme>://<location> <version-id>",
+		Short: "Make an older version of a bundle the current version again",
+		Long: `Make an older version of a bundle the current version again
+
+The version is copied over the current object of a versioned bucket, so the
+newer versions are kept and the restore can itself be undone. The manifest
+written with that version is restored first; when there is none, the current
+manifest is deleted as it describes a different bundle. If the bundle then
+cannot be restored, the manifest is put back as it was.`,
+		Args:         cobra.ExactArgs(2),
+		RunE:         doRestoreVersion,
+		SilenceUsage: true,
+	}
+	addS3PutFlags(Cmd)
+	rootCmd.AddCommand(Cmd)
+}
+
+func doRestoreVersion(cmd *cobra.Command, args []string) error {
+	location, version := args[0], args[1]
+
+	if err := applyS3PutOptions(cmd); err != nil {
+		return err
+	}
+
+	manifestPath := storage.WithExt(location, manifest.Ext)
+	bundles, err := storage.ListVersions(location)
+	if err != nil {
+		return err
+	}
+	manifests, err := storage.ListVersions(manifestPath)
+	if err != nil {
+		return err
+	}
+	manifests = exactVersions(manifests, manifestPath)
+	match, err := storage.CompanionVersion(exactVersions(bundles, location), manifests, version)
+	if err != nil {
+		return fmt.Errorf("error: %s: %w", location, err)
+	}
+
+	// the manifest goes first, so a failed restore of the bundle leaves a
+	// manifest the current bundle does not match, which import refuses,
+	// until it is put back below
+	if err := restoreManifest(manifestPath, match); err != nil {
+		return fmt.Errorf("error restoring the manifest of version %s: %w", version, err)
+	}
+	if match == nil {
+		log.Printf("Version %s of %s has no manifest, the current manifest was deleted", version, location)
+	}
+
+	if err := storage.RestoreVersion(location, version); err != nil {
+		if undoErr := restoreManifest(manifestPath, currentVersion(manifests)); undoErr != nil {
+			return fmt.Errorf("error: %w, and putting back the manifest failed, it no longer matches %s: %v", err, location, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreManifest makes version the current version of the manifest at
+// manifestPath, or deletes the manifest when version is nil
+func restoreManifest(manifestPath string, version *storage.Object) error {
+	if version != nil {
+		return storage.RestoreVersion(manifestPath, version.VersionID)
+	}
+	err := storage.Delete(manifestPath)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// currentVersion returns the current version among versions of one object,
+// nil when the object is deleted
+func currentVersion(versions []storage.Object) *storage.Object {
+	for ii := range versions {
+		if versions[ii].IsLatest && !versions[ii].DeleteMarker {
+			return &versions[ii]
+		}
+	}
+	return nil
+}
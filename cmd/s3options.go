@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	s3ACLFlag            = "s3-acl"
+	s3ObjectLockFlag     = "s3-object-lock-mode"
+	s3RetainUntilFlag    = "s3-retain-until"
+	s3SSEFlag            = "s3-sse"
+	s3SSEKMSKeyFlag      = "s3-sse-kms-key"
+	s3StorageClassFlag   = "s3-storage-class"
+	s3TagsFlag           = "s3-tags"
+	s3RetainUntilFormats = "RFC3339 time, or a period from now such as 90d or 36h"
+)
+
+// addS3PutFlags adds the flags controlling how objects are stored in S3 to
+// a command that uploads
+func addS3PutFlags(cmd *cobra.Command) {
+	cmd.Flags().String(s3SSEFlag, "", "S3 server side encryption [AES256, aws:kms]")
+	cmd.Flags().String(s3SSEKMSKeyFlag, "", "KMS key ARN for S3 server side encryption, implies --s3-sse aws:kms")
+	cmd.Flags().String(s3StorageClassFlag, "", "S3 storage class, such as STANDARD_IA or GLACIER_IR")
+	cmd.Flags().StringToString(s3TagsFlag, map[string]string{}, "S3 object tags, key=value[,...]")
+	cmd.Flags().String(s3ObjectLockFlag, "", "S3 Object Lock mode [GOVERNANCE, COMPLIANCE], needs --"+s3RetainUntilFlag)
+	cmd.Flags().String(s3RetainUntilFlag, "", "S3 Object Lock retain until date, an "+s3RetainUntilFormats)
+	cmd.Flags().String(s3ACLFlag, "", "S3 canned ACL, such as bucket-owner-full-control")
+}
+
+// applyS3PutOptions reads the S3 flags of cmd, or their config keys, and
+// applies them to every object written to S3. The flags are bound when the
+// command runs as dump and upload share the config keys.
+func applyS3PutOptions(cmd *cobra.Command) error {
+	for _, name := range []string{s3SSEFlag, s3SSEKMSKeyFlag, s3StorageClassFlag, s3TagsFlag, s3ObjectLockFlag, s3RetainUntilFlag, s3ACLFlag} {
+		viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+
+	opts := &aws.S3PutOptions{
+		ServerSideEncryption: viper.GetString(s3SSEFlag),
+		SSEKMSKeyID:          viper.GetString(s3SSEKMSKeyFlag),
+		StorageClass:         viper.GetString(s3StorageClassFlag),
+		Tags:                 viper.GetStringMapString(s3TagsFlag),
+		ObjectLockMode:       viper.GetString(s3ObjectLockFlag),
+		ACL:                  viper.GetString(s3ACLFlag),
+	}
+	if until := viper.GetString(s3RetainUntilFlag); until != "" {
+		retainUntil, err := parseRetainUntil(until, time.Now())
+		if err != nil {
+			return fmt.Errorf("error: --%s: %w", s3RetainUntilFlag, err)
+		}
+		opts.RetainUntil = retainUntil
+	}
+	if err := storage.SetS3Options(opts); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
+// parseRetainUntil accepts an RFC3339 time or a period after now given in
+// days, such as 90d, or as a Go duration
+func parseRetainUntil(value string, now time.Time) (time.Time, error) {
+	if until, err := time.Parse(time.RFC3339, value); err == nil {
+		return until, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days > 0 {
+			return now.AddDate(0, 0, days), nil
+		}
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected an %s", value, s3RetainUntilFormats)
+	}
+	return now.Add(period), nil
+}
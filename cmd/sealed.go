@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"github.com/dathan/go-vault-dump/pkg/print"
+	"github.com/dathan/go-vault-dump/pkg/sealed"
+	alsoyaml "github.com/ghodss/yaml"
+)
+
+// isDocument reports whether the start of a file is a JSON or YAML document
+// rather than an encrypted bundle; legacy bundles are base64 and never
+// contain braces or colons
+func isDocument(peek []byte) bool {
+	return !bundle.IsEnvelope(peek) && bytes.ContainsAny(peek, "{:")
+}
+
+// documentEncoding returns the encoding of a JSON or YAML document
+func documentEncoding(data []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return "json"
+	}
+	return "yaml"
+}
+
+// readDocument parses a JSON or YAML dump
+func readDocument(data []byte) (map[string]interface{}, error) {
+	jj, err := alsoyaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(jj, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// isSealedFile reports whether the local file at path is a sealed dump
+func isSealedFile(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return isSealedDocument(data)
+}
+
+// isSealedDocument reports whether data is a sealed dump
+func isSealedDocument(data []byte) bool {
+	doc, err := readDocument(data)
+	return err == nil && sealed.IsSealed(doc)
+}
+
+// encodeDocument renders a dump in encoding, json or yaml
+func encodeDocument(doc map[string]interface{}, encoding string) (string, error) {
+	if encoding == "yaml" {
+		return print.ToYaml(doc)
+	}
+	return print.ToJSON(doc)
+}
+
+// openDocument decrypts the values of a sealed dump, the provider is
+// detected from its metadata and the recipient used is logged
+func openDocument(doc map[string]interface{}) (map[string]interface{}, *sealed.Key, error) {
+	plaintext, key, err := sealed.Open(doc, resolveProvider)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Decrypted with %s", key.Recipient())
+	return plaintext, key, nil
+}
+
+// newSealedKey generates a data key for sealing values, wrapped for the
+// recipients selected by opts
+func newSealedKey(opts *cryptOptions) (*sealed.Key, error) {
+	recipients, context, err := opts.recipients()
+	if err != nil {
+		return nil, err
+	}
+	return sealed.NewKey(&sealed.Options{
+		Recipients: recipients,
+		Context:    context,
+	})
+}
+
+// decryptAny returns the plaintext of either an encrypted bundle or a
+// sealed dump read from r, sealed dumps are rendered in encoding or keep
+// their own when it is empty
+func decryptAny(r *bufio.Reader, encoding string) (io.Reader, error) {
+	peek, _ := r.Peek(512)
+	if !isDocument(peek) {
+		return decryptReader(r)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := readDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sealed.IsSealed(doc) {
+		return nil, errors.New("error: document is neither an encrypted bundle nor a sealed dump")
+	}
+	plaintext, _, err := openDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == "" {
+		encoding = documentEncoding(data)
+	}
+	out, err := encodeDocument(plaintext, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(out), nil
+}
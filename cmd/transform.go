@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/dathan/go-vault-dump/pkg/sealed"
 	"github.com/dathan/go-vault-dump/pkg/transform"
 	"github.com/spf13/cobra"
 )
@@ -40,11 +41,28 @@ func doTransform(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// sealed dumps are transformed in plaintext and sealed again under the
+	// same data key
+	var key *sealed.Key
+	if sealed.IsSealed(secrets) {
+		secrets, key, err = openDocument(secrets)
+		if err != nil {
+			return err
+		}
+	}
+
 	data, err := transform.Transform(transforms, secrets)
 	if err != nil {
 		return err
 	}
 
+	if key != nil {
+		data, err = key.Seal(data)
+		if err != nil {
+			return err
+		}
+	}
+
 	output, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -2,19 +2,35 @@ package cmd
 
 import (
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 
 	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	Cmd := &cobra.Command{
 		Short: "Upload vault bundle",
-		Use:   "upload <file> s3://<bucket>/<key>",
+		Use:   "upload [flags] <file> <scheme>://<location>",
 		Args:  cobra.ExactArgs(2),
 		RunE:  doUpload,
 	}
+	Cmd.Flags().StringSliceVarP(&keyArns, "key", "k", []string{}, "KMS key ARN, encrypt the file while uploading, may be repeated")
+	Cmd.Flags().StringVar(&compression, compressFlag, "", "compress before encrypting [gzip, zstd], requires encryption")
+	Cmd.Flags().StringVar(&encryptWith, encryptWithFlag, "", "encrypt the file while uploading [kms, x25519, passphrase, transit, shamir]")
+	Cmd.Flags().StringSliceVar(&recipients, recipientFlag, []string{}, "X25519 public key to encrypt for, may be repeated")
+	Cmd.Flags().StringVar(&transitKey, transitKeyFlag, "", "vault transit key name")
+	Cmd.Flags().StringToStringVar(&contextKV, kmsContextFlag, map[string]string{}, "extra KMS encryption context pairs, key=value[,...]")
+	Cmd.Flags().IntVar(&split, splitFlag, 0, "number of shares to split the key into with --encrypt-with shamir")
+	Cmd.Flags().IntVar(&threshold, thresholdFlag, 0, "number of shares needed to decrypt with --encrypt-with shamir")
+	Cmd.Flags().StringVar(&sharesDir, sharesDirFlag, "", "directory to write shamir shares to (default print them)")
+	Cmd.Flags().StringVar(&kdf, kdfFlag, "", "passphrase key derivation [argon2id, scrypt] (default argon2id)")
+	addS3PutFlags(Cmd)
 	rootCmd.AddCommand(Cmd)
 }
 
@@ -22,19 +38,91 @@ func doUpload(cmd *cobra.Command, args []string) error {
 	srcPath := args[0]
 	destPath := args[1]
 
-	if len(destPath) <= 5 || destPath[:5] != "s3://" {
-		return errors.New("error: Invalid S3 path.")
+	if _, _, err := storage.Open(destPath); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	if err := applyS3PutOptions(cmd); err != nil {
+		return err
+	}
+
+	var opts *cryptOptions
+	if len(keyArns) != 0 || encryptWith != "" {
+		opts = &cryptOptions{
+			Provider:    encryptWith,
+			KMSKeys:     keyArns,
+			Recipients:  recipients,
+			TransitKey:  transitKey,
+			KDF:         kdf,
+			Compression: compression,
+			Split:       split,
+			Threshold:   threshold,
+			SharesDir:   sharesDir,
+			Context:     contextKV,
+		}
+		opts = opts.withContext("filename", filepath.Base(srcPath))
+		if err := opts.validate(); err != nil {
+			return err
+		}
+	} else if compression != "" {
+		return errors.New("error: --compress requires encryption, compression is recorded in the encrypted bundle")
 	}
 
-	data, err := ioutil.ReadFile(srcPath)
+	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	err = aws.S3Put(destPath, string(data))
+	_, err = uploadFrom(src, destPath, opts)
+	return err
+}
+
+// uploadFrom streams src to the object at dstPath, compressing and
+// encrypting it as described by opts unless opts is nil; the object key is
+// added to the encryption context. Nothing is stored if any step fails, the
+// checksum of the stored object is returned.
+func uploadFrom(src io.Reader, dstPath string, opts *cryptOptions) (manifest.Checksum, error) {
+	dst, err := storage.Put(dstPath)
 	if err != nil {
-		return err
+		return manifest.Checksum{}, err
+	}
+
+	sum := manifest.NewHasher()
+	var ww io.Writer = io.MultiWriter(dst, sum)
+	var ciphertext io.WriteCloser
+	if opts != nil {
+		ciphertext, err = encryptWriter(ww, opts.withContext(objectContext(dstPath)))
+		if err != nil {
+			dst.Abort()
+			return manifest.Checksum{}, err
+		}
+		ww = ciphertext
+	}
+
+	if _, err := io.Copy(ww, src); err != nil {
+		dst.Abort()
+		return manifest.Checksum{}, err
+	}
+	if ciphertext != nil {
+		if err := ciphertext.Close(); err != nil {
+			dst.Abort()
+			return manifest.Checksum{}, err
+		}
 	}
 
-	return nil
+	if err := dst.Close(); err != nil {
+		return manifest.Checksum{}, err
+	}
+	return sum.Sum(), nil
+}
+
+// objectContext returns the encryption context pair naming the object at
+// location: s3_key for S3, the path of the object in other stores
+func objectContext(location string) (string, string) {
+	if storage.Scheme(location) == "s3" {
+		_, key := aws.ParseS3Path(location)
+		return "s3_key", key
+	}
+	_, key, _ := storage.Open(location)
+	return "object_key", key
 }
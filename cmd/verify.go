@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/dathan/go-vault-dump/pkg/dump"
+	"github.com/dathan/go-vault-dump/pkg/load"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+	"github.com/dathan/go-vault-dump/pkg/storage"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+	"github.com/dathan/go-vault-dump/pkg/verify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	scratchAddrFlag  = "scratch-addr"
+	scratchTokenFlag = "scratch-token"
+)
+
+var verifyRestore bool
+
+func init() {
+	Cmd := &cobra.Command{
+		Short: "Check that a backup decrypts, parses and optionally restores",
+		Use:   "verify [flags] <scheme>://<location>",
+		Args:  cobra.ExactArgs(1),
+		RunE:  doVerify,
+		// a failed verification is not a usage error, keep nightly job logs short
+		SilenceUsage: true,
+	}
+	Cmd.Flags().BoolVar(&verifyRestore, "restore", false, "restore into the scratch vault and compare the read-back with the backup")
+	Cmd.Flags().String(scratchAddrFlag, "", "url of the scratch vault used by --restore, its contents are overwritten")
+	Cmd.Flags().String(scratchTokenFlag, "", "token for the scratch vault")
+	viper.BindPFlag(scratchAddrFlag, Cmd.Flags().Lookup(scratchAddrFlag))
+	viper.BindPFlag(scratchTokenFlag, Cmd.Flags().Lookup(scratchTokenFlag))
+	rootCmd.AddCommand(Cmd)
+}
+
+func doVerify(cmd *cobra.Command, args []string) error {
+	if verifyRestore && viper.GetString(scratchAddrFlag) == "" {
+		return fmt.Errorf("error: --restore requires --%s", scratchAddrFlag)
+	}
+
+	srcPath, err := resolveLatest(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := storage.ReadAll(srcPath)
+	if err != nil {
+		return err
+	}
+
+	mm, err := readManifest(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// plaintext dumps such as fingerprints are checked as they are
+	br := bufio.NewReader(bytes.NewReader(data))
+	peek, _ := br.Peek(512)
+	var plaintext io.Reader = br
+	if !isDocument(peek) || isSealedDocument(data) {
+		plaintext, err = decryptAny(br, "json")
+		if err != nil {
+			return err
+		}
+	}
+
+	plainSum := manifest.NewHasher()
+	plain, err := ioutil.ReadAll(plainSum.TeeReader(plaintext))
+	if err != nil {
+		return err
+	}
+	doc, err := readDocument(plain)
+	if err != nil {
+		return fmt.Errorf("error: %s does not parse: %w", srcPath, err)
+	}
+
+	problems := verify.Validate(doc)
+	if mm != nil {
+		objectSum := manifest.NewHasher()
+		objectSum.Write(data)
+		if err := checkManifest(mm, srcPath, objectSum, plainSum); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if mm.SecretCount != len(doc) {
+			problems = append(problems, fmt.Sprintf("manifest records %d secrets, backup holds %d", mm.SecretCount, len(doc)))
+		}
+	} else {
+		log.Printf("%s has no manifest, checksums not verified", srcPath)
+	}
+
+	if verifyRestore && len(problems) == 0 {
+		restored, err := restoreScratch(doc, plain)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, restored...)
+	}
+
+	for _, problem := range problems {
+		log.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("error: %s failed verification with %d problems", srcPath, len(problems))
+	}
+	log.Printf("Verified %s: %d secrets", srcPath, len(doc))
+	return nil
+}
+
+// restoreScratch loads the dump into the scratch vault and reads every path
+// back, database configs are skipped as vault does not return their
+// credentials
+func restoreScratch(doc map[string]interface{}, plain []byte) ([]string, error) {
+	sc, err := vault.NewClient(&vault.Config{
+		Address: viper.GetString(scratchAddrFlag),
+		Token:   viper.GetString(scratchTokenFlag),
+		Retries: 5,
+		Ignore:  &vault.Ignore{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	loader, err := load.New(&load.Config{VaultConfig: sc})
+	if err != nil {
+		return nil, err
+	}
+	if err := loader.FromReader(bytes.NewReader(plain)); err != nil {
+		return nil, err
+	}
+	// secrets the scratch vault refused are problems of their own, not
+	// compared as missing
+	problems := []string{}
+	failed := map[string]bool{}
+	for _, path := range loader.FailedPaths() {
+		problems = append(problems, fmt.Sprintf("%s: failed to load into the scratch vault", path))
+		failed[path] = true
+	}
+
+	expected := make(map[string]interface{}, len(doc))
+	paths := make([]string, 0, len(doc))
+	for path, value := range doc {
+		if failed[path] {
+			continue
+		}
+		if vault.IsDatabaseConfig(path) {
+			log.Printf("Skipping comparison of %s", path)
+			continue
+		}
+		expected[path] = value
+		paths = append(paths, path)
+	}
+
+	if len(paths) == 0 {
+		return problems, nil
+	}
+
+	scraper, err := dump.NewSecretScraper(sc)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	scraper.Run(strings.Join(paths, ","), &wg, runtime.NumCPU())
+	wg.Wait()
+
+	return append(problems, verify.Compare(expected, scraper.Data)...), nil
+}
@@ -2,60 +2,212 @@ package aws
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 const (
 	DefaultAWSRegion string = "us-east-1"
+	// DefaultSessionName names the session of assumed roles in CloudTrail
+	DefaultSessionName string = "vault-dump"
 )
 
-var (
-	AWSRegion   string
-	AWSEndpoint string
-	AWSConfig   aws.Config
-)
-
-func init() {
-	var err error
-
-	AWSRegion = os.Getenv("AWS_REGION")
+// Config describes how to reach AWS, empty fields fall back to the SDK
+// defaults: environment variables, shared config files and instance roles
+type Config struct {
+	Profile string
+	Region  string
+	// Endpoint replaces the AWS endpoints, such as for localstack
+	Endpoint string
+	// AssumeRoleARN is a role assumed with the credentials found above, such
+	// as a role in the backup account
+	AssumeRoleARN string
+	ExternalID    string
+	SessionName   string
+}
 
-	AWSEndpoint = os.Getenv("AWS_ENDPOINT")
+// EnvConfig returns the config given by AWS_REGION and AWS_ENDPOINT
+func EnvConfig() *Config {
+	return &Config{
+		Region:   os.Getenv("AWS_REGION"),
+		Endpoint: os.Getenv("AWS_ENDPOINT"),
+	}
+}
 
-	resolver := aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
-		if AWSEndpoint != "" {
+// Load resolves the region and credentials described by c
+func (c *Config) Load(ctx context.Context) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{}
+	if c.Region != "" {
+		opts = append(opts, config.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		// the SDK quietly ignores profiles that do not exist
+		_, err := config.LoadSharedConfigProfile(ctx, c.Profile, func(o *config.LoadSharedConfigOptions) {
+			if file := os.Getenv("AWS_CONFIG_FILE"); file != "" {
+				o.ConfigFiles = []string{file}
+			}
+			if file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); file != "" {
+				o.CredentialsFiles = []string{file}
+			}
+		})
+		if err != nil {
+			return aws.Config{}, err
+		}
+		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	}
+	if c.Endpoint != "" {
+		endpoint, region := c.Endpoint, c.Region
+		opts = append(opts, config.WithEndpointResolver(aws.EndpointResolverFunc(func(service, _ string) (aws.Endpoint, error) {
 			return aws.Endpoint{
 				PartitionID:   "aws",
-				URL:           AWSEndpoint,
-				SigningRegion: AWSRegion,
+				URL:           endpoint,
+				SigningRegion: region,
 			}, nil
+		})))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if c.AssumeRoleARN != "" {
+		sessionName := c.SessionName
+		if sessionName == "" {
+			sessionName = DefaultSessionName
 		}
-		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if c.ExternalID != "" {
+				o.ExternalID = aws.String(c.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return cfg, nil
+}
+
+// loader loads a Config once, on first use
+type loader struct {
+	config *Config
+	once   sync.Once
+	cfg    aws.Config
+}
+
+func (l *loader) get() aws.Config {
+	l.once.Do(func() {
+		cfg, err := l.config.Load(context.TODO())
+		if err != nil {
+			// fail the first request instead of the process
+			err = fmt.Errorf("error initializing AWS client: %w", err)
+			region := l.config.Region
+			if region == "" {
+				region = DefaultAWSRegion
+			}
+			cfg = aws.Config{
+				Region: region,
+				EndpointResolver: aws.EndpointResolverFunc(func(string, string) (aws.Endpoint, error) {
+					return aws.Endpoint{}, err
+				}),
+				Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+					return aws.Credentials{}, err
+				}),
+			}
+		}
+		l.cfg = cfg
 	})
-	AWSConfig, err = config.LoadDefaultConfig(
-		context.TODO(),
-		config.WithRegion(AWSRegion),
-		config.WithEndpointResolver(resolver),
-	)
-	if err != nil {
-		log.Fatalf("Error initializing AWS client: %s", err)
+	return l.cfg
+}
+
+var (
+	loadersMu sync.Mutex
+	s3Loader  = &loader{config: EnvConfig()}
+	kmsLoader = s3Loader
+)
+
+// Configure sets how S3 and KMS are reached, kms may be nil to use the s3
+// config for both. Without it, the environment is used.
+func Configure(s3cfg *Config, kmscfg *Config) {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+	s3Loader = &loader{config: s3cfg}
+	kmsLoader = s3Loader
+	if kmscfg != nil {
+		kmsLoader = &loader{config: kmscfg}
 	}
+}
 
+func s3Config() aws.Config {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+	return s3Loader.get()
+}
+
+func kmsConfig() aws.Config {
+	loadersMu.Lock()
+	defer loadersMu.Unlock()
+	return kmsLoader.get()
 }
 
 func NewKMSClient() *kms.Client {
-	return kms.NewFromConfig(AWSConfig)
+	return kms.NewFromConfig(kmsConfig())
+}
+
+// NewKMSClientForKey returns a KMS client for the region of keyID when it is
+// an ARN, so keys in other regions can be used
+func NewKMSClientForKey(keyID string) *kms.Client {
+	region := kmsKeyRegion(keyID)
+	if region == "" {
+		return NewKMSClient()
+	}
+	return kms.NewFromConfig(kmsConfig(), func(o *kms.Options) {
+		o.Region = region
+	})
+}
+
+// kmsKeyRegion returns the region of a KMS key or alias ARN, or an empty
+// string for bare key IDs and aliases
+func kmsKeyRegion(keyID string) string {
+	parts := strings.SplitN(keyID, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "kms" {
+		return ""
+	}
+	return parts[3]
 }
 
 func NewS3Client() *s3.Client {
-	return s3.NewFromConfig(AWSConfig, func(o *s3.Options) {
+	return s3.NewFromConfig(s3Config(), func(o *s3.Options) {
 		o.UsePathStyle = true
 	})
 
 }
+
+// bucketRegions holds the regions given with SetBucketRegion
+var bucketRegions sync.Map
+
+// SetBucketRegion sends requests for bucket to region instead of the
+// configured one, for buckets in other regions such as replicas
+func SetBucketRegion(bucket string, region string) {
+	bucketRegions.Store(bucket, region)
+}
+
+// NewS3ClientForBucket returns an S3 client for the region of bucket
+func NewS3ClientForBucket(bucket string) *s3.Client {
+	region, ok := bucketRegions.Load(bucket)
+	if !ok {
+		return NewS3Client()
+	}
+	return s3.NewFromConfig(s3Config(), func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.Region = region.(string)
+	})
+}
@@ -0,0 +1,63 @@
+package aws
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestSuiteConfig(tt *testing.T) {
+
+	dir, err := ioutil.TempDir("", "aws-config-*")
+	if err != nil {
+		tt.Fatalf("FAIL temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(configFile, []byte("[profile backup]\nregion = eu-west-1\n"), 0600); err != nil {
+		tt.Fatalf("FAIL config file: %s", err)
+	}
+	tt.Setenv("AWS_CONFIG_FILE", configFile)
+	tt.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	tt.Setenv("AWS_REGION", "")
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			inputs      Config
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Region", Config{Region: "us-west-2"}, "us-west-2", true},
+			{"Profile region", Config{Profile: "backup"}, "eu-west-1", true},
+			{"Region overrides profile", Config{Profile: "backup", Region: "us-west-2"}, "us-west-2", true},
+			{"Missing profile", Config{Profile: "nosuch"}, "", false},
+			{"Assume role", Config{Region: "us-west-2", AssumeRoleARN: "arn:aws:iam::000000000000:role/backup", ExternalID: "id"}, "us-west-2 assumed", true},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		cfg, err := test.inputs.Load(context.TODO())
+		success = (err == nil)
+		if success {
+			norm = cfg.Region
+			if _, ok := cfg.Credentials.(*aws.CredentialsCache); ok && test.inputs.AssumeRoleARN != "" {
+				norm += " assumed"
+			}
+		}
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
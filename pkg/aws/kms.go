@@ -29,82 +29,181 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
-	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/dathan/go-vault-dump/pkg/bundle"
 )
 
 const (
-	kmsApp    = "vault-dump"
-	kmsCipher = types.DataKeySpecAes256
+	kmsApp = "vault-dump"
 )
 
-func KMSEncrypt(plaintext string, kmsKey string) (string, error) {
+// KMSProvider wraps bundle data keys with an AWS KMS key
+type KMSProvider struct {
+	KeyID   string
+	Context map[string]string
+}
 
-	// get data encryption keys from KMS
-	kmssvc := NewKMSClient()
-	params := &kms.GenerateDataKeyInput{
-		KeyId:   aws.String(kmsKey),
-		KeySpec: kmsCipher,
+// NewKMSProvider returns a provider wrapping data keys under keyID, the
+// key ID is not needed to unwrap
+func NewKMSProvider(keyID string) *KMSProvider {
+	return &KMSProvider{KeyID: keyID}
+}
+
+func (k *KMSProvider) Name() string {
+	return bundle.KMS
+}
+
+func (k *KMSProvider) Wrap(key []byte) (*bundle.Stanza, error) {
+	if k.KeyID == "" {
+		return nil, errors.New("KMS key ARN must be specified")
 	}
-	resp, err := kmssvc.GenerateDataKey(context.TODO(), params)
+	kmssvc := NewKMSClientForKey(k.KeyID)
+	resp, err := kmssvc.Encrypt(context.TODO(), &kms.EncryptInput{
+		KeyId:             aws.String(k.KeyID),
+		Plaintext:         key,
+		EncryptionContext: k.Context,
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	plainkey := resp.Plaintext
-	cipherkey := resp.CiphertextBlob
+	return &bundle.Stanza{
+		Provider: bundle.KMS,
+		KeyID:    k.KeyID,
+		Wrapped:  resp.CiphertextBlob,
+	}, nil
+}
 
-	// generate salt
-	salt := make([]byte, aes.BlockSize)
-	_, err = rand.Read(salt)
+func (k *KMSProvider) Unwrap(stanza *bundle.Stanza) ([]byte, error) {
+	kmssvc := NewKMSClientForKey(stanza.KeyID)
+	keyparams := &kms.DecryptInput{
+		CiphertextBlob:    stanza.Wrapped,
+		EncryptionContext: stanza.Context,
+	}
+	if stanza.KeyID != "" {
+		keyparams.KeyId = aws.String(stanza.KeyID)
+	}
+	response, err := kmssvc.Decrypt(context.TODO(), keyparams)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
+
+func (k *KMSProvider) OpenLegacy(data []byte) ([]byte, error) {
+	plaintext, err := kmsDecryptLegacy(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plaintext), nil
+}
+
+// KMSResolver resolves KMS and legacy stanzas to a KMSProvider
+func KMSResolver(stanza *bundle.Stanza) (bundle.Provider, error) {
+	if stanza.Provider != bundle.KMS && stanza.Provider != bundle.Legacy {
+		return nil, fmt.Errorf("no %s provider configured", stanza.Provider)
+	}
+	return &KMSProvider{}, nil
+}
+
+// KMSEncryptionContext returns the encryption context for a bundle: the
+// application name followed by pairs, which may not redefine it
+func KMSEncryptionContext(pairs map[string]string) (map[string]string, error) {
+	context := map[string]string{"app": kmsApp}
+	for kk, vv := range pairs {
+		if _, ok := context[kk]; ok {
+			return nil, fmt.Errorf("encryption context key %q is reserved", kk)
+		}
+		context[kk] = vv
 	}
+	return context, nil
+}
 
-	// apply pkcs#7 padding tp plaintext
-	data := []byte(plaintext)
-	padding := aes.BlockSize - (len(data) % aes.BlockSize)
-	for ii := 0; ii < padding; ii++ {
-		data = append(data, byte(padding))
+// KMSProviders returns a provider for every key in kmsKeys, the data key
+// is wrapped once per key so any one of them can decrypt the bundle
+func KMSProviders(kmsKeys []string, context map[string]string) []bundle.Provider {
+	providers := make([]bundle.Provider, 0, len(kmsKeys))
+	for _, kk := range kmsKeys {
+		providers = append(providers, &KMSProvider{KeyID: kk, Context: context})
 	}
+	return providers
+}
 
-	// encrypt padded plaintext
-	c, err := aes.NewCipher(plainkey)
+// KMSEncryptWriter returns a writer that compresses and encrypts everything
+// written to it into w, under a data key wrapped by each of kmsKeys with the
+// encryption context built from pairs. Close must be called to seal the
+// final chunk.
+func KMSEncryptWriter(w io.Writer, kmsKeys []string, compression string, pairs map[string]string) (io.WriteCloser, error) {
+	context, err := KMSEncryptionContext(pairs)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	mode := cipher.NewCBCEncrypter(c, salt)
-	mode.CryptBlocks(data, data)
+	return bundle.NewWriter(w, &bundle.Options{
+		Recipients:  KMSProviders(kmsKeys, context),
+		Compression: compression,
+		Context:     context,
+	})
+}
 
-	// combine metadata with ciphertext and base64 encode
-	sep := []byte{0, 1, 0, 1, 0, 1}
-	bufferslice := [][]byte{cipherkey, salt, data}
-	combined := bytes.Join(bufferslice, sep)
-	encoded := base64.URLEncoding.EncodeToString(combined)
+// KMSDecryptReader returns a reader over the plaintext of a KMS encrypted
+// bundle read from r, including bundles in the legacy AES-256-CBC format.
+// Each wrapped key is tried in turn, the reader records the one used.
+func KMSDecryptReader(r io.Reader) (*bundle.Reader, error) {
+	return bundle.NewReader(r, KMSResolver)
+}
 
-	return encoded, nil
+// KMSEncrypt encrypts plaintext in memory, see KMSEncryptWriter
+func KMSEncrypt(plaintext string, kmsKeys ...string) (string, error) {
+	var buf bytes.Buffer
+	ww, err := KMSEncryptWriter(&buf, kmsKeys, "", nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(ww, plaintext); err != nil {
+		return "", err
+	}
+	if err := ww.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
+// KMSDecrypt decrypts a bundle in memory, see KMSDecryptReader, and logs
+// which key was used
 func KMSDecrypt(ciphertext string) (string, error) {
+	rr, err := KMSDecryptReader(strings.NewReader(ciphertext))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := ioutil.ReadAll(rr)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Decrypted with %s", rr)
+	return string(plaintext), nil
+}
+
+// kmsDecryptLegacy reads the base64 encoded AES-256-CBC bundles written by
+// earlier releases
+func kmsDecryptLegacy(ciphertext string) (string, error) {
 
 	// split metadata and ciphertext
 	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
 	}
-	sep := []byte{0, 1, 0, 1, 0, 1}
-	sliced := bytes.SplitN(decoded, sep, 3)
-	if len(sliced) < 3 {
-		return "", errors.New("Failed to extract encrypted metadata")
+	cipherkey, salt, data, err := splitLegacyBundle(decoded)
+	if err != nil {
+		return "", err
 	}
-	cipherkey := sliced[0]
-	salt := sliced[1]
-	data := sliced[2]
 
 	// decrypt decryption key
 	kmssvc := NewKMSClient()
@@ -146,3 +245,26 @@ func KMSDecrypt(ciphertext string) (string, error) {
 
 	return string(data[:len(data)-int(padding)]), nil
 }
+
+// splitLegacyBundle separates key, IV and ciphertext of a legacy bundle. The
+// separator may also occur inside the KMS ciphertext blob, so rather than
+// splitting on its first occurrence we look for the one followed by exactly
+// one IV and a second separator.
+func splitLegacyBundle(decoded []byte) ([]byte, []byte, []byte, error) {
+	sep := []byte{0, 1, 0, 1, 0, 1}
+	for ii := 0; ii+2*len(sep)+aes.BlockSize <= len(decoded); ii++ {
+		if !bytes.Equal(decoded[ii:ii+len(sep)], sep) {
+			continue
+		}
+		ivStart := ii + len(sep)
+		dataStart := ivStart + aes.BlockSize + len(sep)
+		if !bytes.Equal(decoded[ivStart+aes.BlockSize:dataStart], sep) {
+			continue
+		}
+		if (len(decoded)-dataStart)%aes.BlockSize != 0 {
+			continue
+		}
+		return decoded[:ii], decoded[ivStart : ivStart+aes.BlockSize], decoded[dataStart:], nil
+	}
+	return nil, nil, nil, errors.New("Failed to extract encrypted metadata")
+}
@@ -1,7 +1,10 @@
 package aws
 
 import (
+	"bytes"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -42,3 +45,110 @@ func TestSuiteKMS(tt *testing.T) {
 		}
 	}
 }
+
+func TestSuiteKMSLegacy(tt *testing.T) {
+
+	sep := []byte{0, 1, 0, 1, 0, 1}
+	iv := bytes.Repeat([]byte{2}, 16)
+	data := bytes.Repeat([]byte{3}, 32)
+	blob := append([]byte{9}, sep...) // key blob containing the separator
+	legacy := bytes.Join([][]byte{blob, iv, data}, sep)
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []byte
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Split legacy bundle with separator in key", "SplitLegacy", legacy, string(blob), true},
+			{"Split legacy bundle without separator", "SplitLegacy", []byte("xyzzy"), "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "SplitLegacy":
+			cipherkey, salt, payload, err := splitLegacyBundle(test.inputs)
+			success = (err == nil) && bytes.Equal(salt, iv) && bytes.Equal(payload, data)
+			norm = string(cipherkey)
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
+
+func TestSuiteKMSKeyRegion(tt *testing.T) {
+
+	var (
+		norm  string
+		tests = []struct {
+			description string
+			inputs      string
+			normOutput  string
+		}{
+			{"Key ARN", "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab", "eu-west-1"},
+			{"Alias ARN", "arn:aws:kms:us-west-2:111122223333:alias/vault-dump", "us-west-2"},
+			{"Key ID", "1234abcd-12ab-34cd-56ef-1234567890ab", ""},
+			{"Alias", "alias/vault-dump", ""},
+			{"Other service ARN", "arn:aws:s3:::bucket", ""},
+		}
+	)
+
+	for _, test := range tests {
+		norm = kmsKeyRegion(test.inputs)
+		if norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
+
+func TestSuiteKMSEncryptionContext(tt *testing.T) {
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			inputs      map[string]string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Application only", nil, "app=vault-dump", true},
+			{"Extra pairs", map[string]string{"team": "infra"}, "app=vault-dump,team=infra", true},
+			{"Reserved key", map[string]string{"app": "other"}, "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		context, err := KMSEncryptionContext(test.inputs)
+		success = (err == nil)
+		if success {
+			pairs := []string{}
+			for kk, vv := range context {
+				pairs = append(pairs, kk+"="+vv)
+			}
+			sort.Strings(pairs)
+			norm = strings.Join(pairs, ",")
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -1,79 +1,511 @@
 package aws
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/dathan/go-vault-dump/pkg/vault"
 )
 
+const (
+	// s3PartSize is the amount of data buffered per multipart upload part,
+	// objects smaller than this are uploaded with a single PutObject
+	s3PartSize = 8 * 1024 * 1024
+)
+
+// s3ListPageSize is the number of keys requested per ListObjectsV2 call, zero
+// leaves it to S3 (1000)
+var s3ListPageSize int32
+
 type S3ListResult struct {
-	Key  string
-	Size int
+	Key          string
+	Size         int
+	LastModified time.Time
+	StorageClass string
+	ETag         string
+	// VersionID, IsLatest and DeleteMarker are set by S3ListVersions
+	VersionID    string
+	IsLatest     bool
+	DeleteMarker bool
 }
 
-func S3Put(s3path string, body string) error {
+// ParseS3Path splits s3://bucket/key into bucket and key
+func ParseS3Path(s3path string) (string, string) {
 	s3bucket := strings.Split(s3path[len("s3://"):], "/")[0]
-	s3key := s3path[len("s3://"+s3bucket+"/"):]
+	s3key := vault.EnsureNoLeadingSlash(s3path[len("s3://"+s3bucket):])
+	return s3bucket, s3key
+}
+
+// S3ObjectWriter streams an object to S3, see S3Writer
+type S3ObjectWriter struct {
+	client   *s3.Client
+	s3path   string
+	bucket   string
+	key      string
+	buf      bytes.Buffer
+	options  *S3PutOptions
+	uploadID *string
+	parts    []types.CompletedPart
+	closed   bool
+	err      error
+}
+
+// S3Writer returns a writer that streams to the object at s3path, large
+// objects are sent as a multipart upload so only one part is held in memory.
+// The object is only visible once Close returns without error.
+func S3Writer(s3path string) (*S3ObjectWriter, error) {
+	return S3WriterWithOptions(s3path, nil)
+}
 
-	client := NewS3Client()
-	params := &s3.PutObjectInput{
+// S3WriterWithOptions is S3Writer applying opts, such as server side
+// encryption or Object Lock, to the object
+func S3WriterWithOptions(s3path string, opts *S3PutOptions) (*S3ObjectWriter, error) {
+	if len(s3path) <= len("s3://") || s3path[:len("s3://")] != "s3://" {
+		return nil, errors.New("error: Invalid S3 path.")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	s3bucket, s3key := ParseS3Path(s3path)
+	return &S3ObjectWriter{
+		client:  NewS3ClientForBucket(s3bucket),
+		s3path:  s3path,
+		bucket:  s3bucket,
+		key:     s3key,
+		options: opts,
+	}, nil
+}
+
+// Key returns the key of the object within its bucket
+func (w *S3ObjectWriter) Key() string {
+	return w.key
+}
+
+func (w *S3ObjectWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errors.New("write to closed S3 object")
+	}
+	nn, _ := w.buf.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if w.err = w.uploadPart(w.buf.Next(s3PartSize)); w.err != nil {
+			w.abort()
+			return nn, w.err
+		}
+	}
+	return nn, nil
+}
+
+func (w *S3ObjectWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.uploadID == nil {
+		input := &s3.PutObjectInput{
+			Bucket: &w.bucket,
+			Key:    &w.key,
+			Body:   bytes.NewReader(w.buf.Bytes()),
+		}
+		w.options.applyPut(input, w.buf.Bytes())
+		_, w.err = w.client.PutObject(context.TODO(), input)
+		if w.err != nil {
+			return w.err
+		}
+		log.Printf("File uploaded to %s", w.s3path)
+		return nil
+	}
+
+	if w.buf.Len() > 0 {
+		if w.err = w.uploadPart(w.buf.Bytes()); w.err != nil {
+			w.abort()
+			return w.err
+		}
+	}
+	_, w.err = w.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          &w.bucket,
+		Key:             &w.key,
+		UploadId:        w.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+	log.Printf("File uploaded to %s", w.s3path)
+	return nil
+}
+
+func (w *S3ObjectWriter) uploadPart(part []byte) error {
+	if w.uploadID == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket: &w.bucket,
+			Key:    &w.key,
+		}
+		w.options.applyCreate(input)
+		created, err := w.client.CreateMultipartUpload(context.TODO(), input)
+		if err != nil {
+			return err
+		}
+		w.uploadID = created.UploadId
+	}
+
+	number := int32(len(w.parts) + 1)
+	input := &s3.UploadPartInput{
+		Bucket:     &w.bucket,
+		Key:        &w.key,
+		UploadId:   w.uploadID,
+		PartNumber: number,
+		Body:       bytes.NewReader(part),
+	}
+	w.options.applyPart(input, part)
+	resp, err := w.client.UploadPart(context.TODO(), input)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: resp.ETag, PartNumber: number})
+	return nil
+}
+
+// Abort discards everything written so far without creating the object
+func (w *S3ObjectWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.err = errors.New("upload aborted")
+	w.abort()
+}
+
+// abort discards an unfinished multipart upload so no partial object or
+// orphaned parts are left behind
+func (w *S3ObjectWriter) abort() {
+	if w.uploadID == nil {
+		return
+	}
+	_, err := w.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   &w.bucket,
+		Key:      &w.key,
+		UploadId: w.uploadID,
+	})
+	if err != nil {
+		log.Printf("failed to abort upload to %s: %s", w.s3path, err)
+	}
+}
+
+// S3Reader returns the body of the object at s3path, the caller must close it
+func S3Reader(s3path string) (io.ReadCloser, error) {
+	return S3ReaderVersion(s3path, "")
+}
+
+// S3ReaderVersion returns the body of versionID of the object at s3path, or
+// of the current version when versionID is empty
+func S3ReaderVersion(s3path string, versionID string) (io.ReadCloser, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	input := &s3.GetObjectInput{
 		Bucket: &s3bucket,
 		Key:    &s3key,
-		Body:   strings.NewReader(body),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
 	}
 
-	_, err := client.PutObject(context.TODO(), params)
+	client := NewS3ClientForBucket(s3bucket)
+	result, err := client.GetObject(context.TODO(), input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// S3ReaderRange returns the first length bytes of versionID of the object at
+// s3path, or of the current version when versionID is empty, the caller must
+// close it
+func S3ReaderRange(s3path string, versionID string, length int64) (io.ReadCloser, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	input := &s3.GetObjectInput{
+		Bucket: &s3bucket,
+		Key:    &s3key,
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", length-1)),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	client := NewS3ClientForBucket(s3bucket)
+	result, err := client.GetObject(context.TODO(), input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+func S3Put(s3path string, body string) error {
+	ww, err := S3Writer(s3path)
 	if err != nil {
 		return err
 	}
-	log.Printf("File uploaded to %s", s3path)
-	return nil
+	if _, err := io.WriteString(ww, body); err != nil {
+		return err
+	}
+	return ww.Close()
 }
 
 func S3List(s3path string, ext string) ([]S3ListResult, error) {
 
-	s3bucket := strings.Split(s3path[len("s3://"):], "/")[0]
-	s3prefix := vault.EnsureNoLeadingSlash(s3path[len("s3://"+s3bucket):])
+	s3bucket, s3prefix := ParseS3Path(s3path)
 
-	client := NewS3Client()
-	output, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(s3bucket),
-		Prefix: aws.String(s3prefix),
+	client := NewS3ClientForBucket(s3bucket)
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s3bucket),
+		Prefix:  aws.String(s3prefix),
+		MaxKeys: s3ListPageSize,
 	})
-	if err != nil {
-		return nil, err
+
+	results := make([]S3ListResult, 0)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, vv := range output.Contents {
+			keyStr := aws.ToString(vv.Key)
+			if !strings.HasSuffix(keyStr, ext) {
+				continue
+			}
+			results = append(results, S3ListResult{
+				Key:          keyStr,
+				Size:         int(vv.Size),
+				LastModified: aws.ToTime(vv.LastModified),
+				StorageClass: string(vv.StorageClass),
+				ETag:         strings.Trim(aws.ToString(vv.ETag), `"`),
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// S3ListVersions returns every version and delete marker of the objects
+// under s3path whose key ends in ext, ordered by key and newest first
+func S3ListVersions(s3path string, ext string) ([]S3ListResult, error) {
+
+	s3bucket, s3prefix := ParseS3Path(s3path)
+
+	client := NewS3ClientForBucket(s3bucket)
+	input := &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(s3bucket),
+		Prefix:  aws.String(s3prefix),
+		MaxKeys: s3ListPageSize,
 	}
 
 	results := make([]S3ListResult, 0)
-	for _, vv := range output.Contents {
-		keyStr := aws.ToString(vv.Key)
-		if keyStr[len(keyStr)-len(ext):] == ext {
-			results = append(results, S3ListResult{Key: keyStr, Size: int(vv.Size)})
+	for {
+		output, err := client.ListObjectVersions(context.TODO(), input)
+		if err != nil {
+			return nil, err
+		}
+		for _, vv := range output.Versions {
+			if !strings.HasSuffix(aws.ToString(vv.Key), ext) {
+				continue
+			}
+			results = append(results, S3ListResult{
+				Key:          aws.ToString(vv.Key),
+				Size:         int(vv.Size),
+				LastModified: aws.ToTime(vv.LastModified),
+				StorageClass: string(vv.StorageClass),
+				ETag:         strings.Trim(aws.ToString(vv.ETag), `"`),
+				VersionID:    aws.ToString(vv.VersionId),
+				IsLatest:     vv.IsLatest,
+			})
 		}
+		for _, vv := range output.DeleteMarkers {
+			if !strings.HasSuffix(aws.ToString(vv.Key), ext) {
+				continue
+			}
+			results = append(results, S3ListResult{
+				Key:          aws.ToString(vv.Key),
+				LastModified: aws.ToTime(vv.LastModified),
+				VersionID:    aws.ToString(vv.VersionId),
+				IsLatest:     vv.IsLatest,
+				DeleteMarker: true,
+			})
+		}
+		if !output.IsTruncated {
+			break
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
 	}
 
+	sort.SliceStable(results, func(ii, jj int) bool {
+		if results[ii].Key != results[jj].Key {
+			return results[ii].Key < results[jj].Key
+		}
+		return results[ii].LastModified.After(results[jj].LastModified)
+	})
 	return results, nil
 }
 
-func S3Get(s3path string) ([]byte, error) {
-	s3bucket := strings.Split(s3path[len("s3://"):], "/")[0]
-	s3key := vault.EnsureNoLeadingSlash(s3path[len("s3://"+s3bucket):])
+// S3RestoreVersion copies versionID of the object at s3path over the current
+// version, so it becomes the current version again, applying opts as for
+// new objects
+func S3RestoreVersion(s3path string, versionID string, opts *S3PutOptions) error {
+	if versionID == "" {
+		return errors.New("error: a version id is required")
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	input := &s3.CopyObjectInput{
+		Bucket:     &s3bucket,
+		Key:        &s3key,
+		CopySource: aws.String(fmt.Sprintf("%s/%s?versionId=%s", s3bucket, url.PathEscape(s3key), url.QueryEscape(versionID))),
+	}
+	opts.applyCopy(input)
+
+	client := NewS3ClientForBucket(s3bucket)
+	if _, err := client.CopyObject(context.TODO(), input); err != nil {
+		return err
+	}
+	log.Printf("Version %s of %s restored", versionID, s3path)
+	return nil
+}
+
+// S3Delete removes the object at s3path
+func S3Delete(s3path string) error {
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	client := NewS3ClientForBucket(s3bucket)
+	_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: &s3bucket,
+		Key:    &s3key,
+	})
+	return err
+}
+
+// S3Stat returns the size of the object at s3path without downloading it
+func S3Stat(s3path string) (S3ListResult, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	client := NewS3ClientForBucket(s3bucket)
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: &s3bucket,
+		Key:    &s3key,
+	})
+	if err != nil {
+		return S3ListResult{}, err
+	}
+	return S3ListResult{
+		Key:          s3key,
+		Size:         int(head.ContentLength),
+		LastModified: aws.ToTime(head.LastModified),
+		StorageClass: string(head.StorageClass),
+		ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+	}, nil
+}
+
+// S3PutIf writes body to the object at s3path only if it is in the expected
+// state: absent when etag is empty, otherwise still holding that ETag. The
+// ETag of the new object is returned, IsS3PreconditionFailed reports a failed
+// condition. Object Lock options are left out so the object can be deleted.
+func S3PutIf(s3path string, body []byte, etag string, opts *S3PutOptions) (string, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
+
+	input := &s3.PutObjectInput{
+		Bucket: &s3bucket,
+		Key:    &s3key,
+		Body:   bytes.NewReader(body),
+	}
+	if opts != nil {
+		unlocked := *opts
+		unlocked.ObjectLockMode, unlocked.RetainUntil = "", time.Time{}
+		unlocked.applyPut(input, body)
+	}
+	// buckets with a default retention period require it
+	input.ContentMD5 = contentMD5(body)
+
+	condition := smithyhttp.SetHeaderValue("If-None-Match", "*")
+	if etag != "" {
+		condition = smithyhttp.SetHeaderValue("If-Match", `"`+etag+`"`)
+	}
+	client := NewS3ClientForBucket(s3bucket)
+	result, err := client.PutObject(context.TODO(), input, s3.WithAPIOptions(condition))
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(aws.ToString(result.ETag), `"`), nil
+}
+
+// S3GetETag returns the contents of the object at s3path and its ETag
+func S3GetETag(s3path string) ([]byte, string, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
 
-	client := NewS3Client()
+	client := NewS3ClientForBucket(s3bucket)
 	result, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: &s3bucket,
 		Key:    &s3key,
 	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer result.Body.Close()
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, strings.Trim(aws.ToString(result.ETag), `"`), nil
+}
+
+// IsS3PreconditionFailed reports whether err means a conditional write found
+// the object in another state, or lost a race with another conditional write
+func IsS3PreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict")
+}
+
+// IsS3NotFound reports whether err means the object does not exist
+func IsS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound")
+}
+
+func S3Get(s3path string) ([]byte, error) {
+	body, err := S3Reader(s3path)
 	if err != nil {
 		return []byte(""), err
 	}
+	defer body.Close()
 
-	data, err := ioutil.ReadAll(result.Body)
+	data, err := ioutil.ReadAll(body)
 	if err != nil {
 		return []byte(""), err
 	}
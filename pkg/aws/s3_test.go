@@ -1,10 +1,91 @@
 package aws
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// putWithOptions stores body at s3path applying opts
+func putWithOptions(s3path string, body string, opts *S3PutOptions) error {
+	ww, err := S3WriterWithOptions(s3path, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := ww.Write([]byte(body)); err != nil {
+		return err
+	}
+	return ww.Close()
+}
+
+// describeObject returns the encryption, storage class, tags and lock mode
+// of the object at s3path
+func describeObject(s3path string) (string, error) {
+	s3bucket, s3key := ParseS3Path(s3path)
+	client := NewS3Client()
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{Bucket: &s3bucket, Key: &s3key})
+	if err != nil {
+		return "", err
+	}
+	tagging, err := client.GetObjectTagging(context.TODO(), &s3.GetObjectTaggingInput{Bucket: &s3bucket, Key: &s3key})
+	if err != nil {
+		return "", err
+	}
+	tags := []string{}
+	for _, tag := range tagging.TagSet {
+		tags = append(tags, fmt.Sprintf("%s=%s", *tag.Key, *tag.Value))
+	}
+	return fmt.Sprintf("%s,%s,%s,%s", head.ServerSideEncryption, head.StorageClass, strings.Join(tags, "&"), head.ObjectLockMode), nil
+}
+
+// readOldVersion overwrites the object at s3path, reads back and restores
+// its first version, returning the version count and both reads
+func readOldVersion(s3path string) (string, error) {
+	for _, body := range []string{"one", "two"} {
+		if err := S3Put(s3path, body); err != nil {
+			return "", err
+		}
+	}
+	_, s3key := ParseS3Path(s3path)
+	results, err := S3ListVersions(s3path, "")
+	if err != nil {
+		return "", err
+	}
+	versions := []S3ListResult{}
+	for _, rr := range results {
+		if rr.Key == s3key {
+			versions = append(versions, rr)
+		}
+	}
+	if len(versions) < 2 {
+		return "", fmt.Errorf("expected 2 versions, got %d", len(versions))
+	}
+
+	oldest := versions[len(versions)-1].VersionID
+	body, err := S3ReaderVersion(s3path, oldest)
+	if err != nil {
+		return "", err
+	}
+	old, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return "", err
+	}
+	if err := S3RestoreVersion(s3path, oldest, nil); err != nil {
+		return "", err
+	}
+	current, err := S3Get(s3path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d,%s,%s", len(versions), old, current), nil
+}
+
 func TestSuiteS3(tt *testing.T) {
 	var (
 		norm    string
@@ -25,13 +106,23 @@ func TestSuiteS3(tt *testing.T) {
 			{"List bucket", "List", []string{"s3://test", ""}, "test.txt", true},
 			{"List bucket with ext", "List", []string{"s3://test", ".txt"}, "test.txt", true},
 			{"List bucket missing ext", "List", []string{"s3://test", ".xyzzy"}, "", true},
+			{"Put second object", "Put", []string{"s3://test/test2.txt", "testing"}, "", true},
+			{"List bucket in pages", "ListPaged", []string{"s3://test", ".txt"}, "test.txt,test2.txt", true},
 			{"Get from bucket", "Get", []string{"s3://test/test.txt"}, "testing", true},
+			{"Put with options", "PutOptions", []string{"s3://test/options.txt", ""}, "AES256,STANDARD_IA,team=vault,", true},
+			{"Put with object lock", "PutOptions", []string{"s3://test-lock/locked.txt", "GOVERNANCE"}, "AES256,STANDARD_IA,team=vault,GOVERNANCE", true},
+			{"Read and restore old version", "Versions", []string{"s3://test-lock/versioned.txt"}, "2,one,one", true},
+			{"Versions of unversioned bucket", "Versions", []string{"s3://test/unversioned.txt"}, "", false},
 		}
 	)
 	for _, test := range tests {
 		switch test.action {
-		case "List":
+		case "List", "ListPaged":
+			if test.action == "ListPaged" {
+				s3ListPageSize = 1
+			}
 			out, err := S3List(test.inputs[0], test.inputs[1])
+			s3ListPageSize = 0
 			keys := make([]string, len(out))
 			for ii, item := range out {
 				keys[ii] = item.Key
@@ -45,6 +136,25 @@ func TestSuiteS3(tt *testing.T) {
 		case "Put":
 			err := S3Put(test.inputs[0], test.inputs[1])
 			success = (err == nil)
+		case "Versions":
+			var err error
+			norm, err = readOldVersion(test.inputs[0])
+			success = (err == nil)
+		case "PutOptions":
+			opts := &S3PutOptions{
+				ServerSideEncryption: "AES256",
+				StorageClass:         "STANDARD_IA",
+				Tags:                 map[string]string{"team": "vault"},
+				ObjectLockMode:       test.inputs[1],
+			}
+			if opts.ObjectLockMode != "" {
+				opts.RetainUntil = time.Now().Add(time.Hour)
+			}
+			err := putWithOptions(test.inputs[0], "testing", opts)
+			if err == nil {
+				norm, err = describeObject(test.inputs[0])
+			}
+			success = (err == nil)
 		}
 
 		if success == test.isSuccess && (!success || norm == test.normOutput) {
@@ -56,3 +166,37 @@ func TestSuiteS3(tt *testing.T) {
 		}
 	}
 }
+
+func TestSuiteS3PutOptions(tt *testing.T) {
+	var (
+		success bool
+		tests   = []struct {
+			description string
+			inputs      S3PutOptions
+			isSuccess   bool
+		}{
+			{"No options", S3PutOptions{}, true},
+			{"SSE KMS key", S3PutOptions{SSEKMSKeyID: "arn:aws:kms:us-east-1:000000000000:key/test"}, true},
+			{"SSE KMS key with AES256", S3PutOptions{ServerSideEncryption: "AES256", SSEKMSKeyID: "arn"}, false},
+			{"Unknown encryption", S3PutOptions{ServerSideEncryption: "rot13"}, false},
+			{"Glacier instant retrieval", S3PutOptions{StorageClass: "GLACIER_IR"}, true},
+			{"Unknown storage class", S3PutOptions{StorageClass: "FLOPPY"}, false},
+			{"Object lock", S3PutOptions{ObjectLockMode: "COMPLIANCE", RetainUntil: time.Now().Add(time.Hour)}, true},
+			{"Object lock without date", S3PutOptions{ObjectLockMode: "COMPLIANCE"}, false},
+			{"Date without object lock", S3PutOptions{RetainUntil: time.Now().Add(time.Hour)}, false},
+			{"Object lock in the past", S3PutOptions{ObjectLockMode: "GOVERNANCE", RetainUntil: time.Now().Add(-time.Hour)}, false},
+			{"Canned ACL", S3PutOptions{ACL: "bucket-owner-full-control"}, true},
+			{"Unknown ACL", S3PutOptions{ACL: "everyone"}, false},
+		}
+	)
+	for _, test := range tests {
+		opts := test.inputs
+		err := opts.Validate()
+		success = (err == nil)
+		if success == test.isSuccess {
+			tt.Logf("PASS %s", test.description)
+		} else {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package aws
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// storageClassGlacierIR is Glacier Instant Retrieval, which is newer than the
+// storage classes known to the SDK
+const storageClassGlacierIR types.StorageClass = "GLACIER_IR"
+
+// S3PutOptions are applied to every object written by an S3ObjectWriter
+type S3PutOptions struct {
+	// ServerSideEncryption is AES256 or aws:kms, it defaults to aws:kms when
+	// SSEKMSKeyID is set
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	StorageClass         string
+	Tags                 map[string]string
+	// ObjectLockMode is GOVERNANCE or COMPLIANCE and needs RetainUntil
+	ObjectLockMode string
+	RetainUntil    time.Time
+	ACL            string
+}
+
+// Validate checks the options against the values S3 accepts
+func (o *S3PutOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.SSEKMSKeyID != "" && o.ServerSideEncryption == "" {
+		o.ServerSideEncryption = string(types.ServerSideEncryptionAwsKms)
+	}
+	if err := oneOf("server side encryption", o.ServerSideEncryption, types.ServerSideEncryption("").Values()); err != nil {
+		return err
+	}
+	if o.SSEKMSKeyID != "" && o.ServerSideEncryption != string(types.ServerSideEncryptionAwsKms) {
+		return fmt.Errorf("an SSE KMS key needs server side encryption %s", types.ServerSideEncryptionAwsKms)
+	}
+	if err := oneOf("storage class", o.StorageClass, append(types.StorageClass("").Values(), storageClassGlacierIR)); err != nil {
+		return err
+	}
+	if err := oneOf("object lock mode", o.ObjectLockMode, types.ObjectLockMode("").Values()); err != nil {
+		return err
+	}
+	if o.ObjectLockMode != "" && o.RetainUntil.IsZero() {
+		return fmt.Errorf("object lock mode %s needs a retain until date", o.ObjectLockMode)
+	}
+	if o.ObjectLockMode == "" && !o.RetainUntil.IsZero() {
+		return fmt.Errorf("a retain until date needs an object lock mode")
+	}
+	if !o.RetainUntil.IsZero() && !o.RetainUntil.After(time.Now()) {
+		return fmt.Errorf("retain until date %s is in the past", o.RetainUntil.Format(time.RFC3339))
+	}
+	return oneOf("ACL", o.ACL, types.ObjectCannedACL("").Values())
+}
+
+// oneOf checks that value, when set, is one of values, a slice of one of
+// the SDK's string enums
+func oneOf(what string, value string, values interface{}) error {
+	if value == "" {
+		return nil
+	}
+	enum := reflect.ValueOf(values)
+	for ii := 0; ii < enum.Len(); ii++ {
+		if enum.Index(ii).String() == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported %s %q, we only accept: %v", what, value, values)
+}
+
+// applyPut sets the options on a single part upload of body
+func (o *S3PutOptions) applyPut(input *s3.PutObjectInput, body []byte) {
+	if o == nil {
+		return
+	}
+	input.ServerSideEncryption = types.ServerSideEncryption(o.ServerSideEncryption)
+	input.SSEKMSKeyId = optionalString(o.SSEKMSKeyID)
+	input.StorageClass = types.StorageClass(o.StorageClass)
+	input.Tagging = optionalString(o.tagging())
+	input.ACL = types.ObjectCannedACL(o.ACL)
+	if o.ObjectLockMode != "" {
+		input.ObjectLockMode = types.ObjectLockMode(o.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(o.RetainUntil)
+		input.ContentMD5 = contentMD5(body)
+	}
+}
+
+// applyCreate sets the options on the start of a multipart upload
+func (o *S3PutOptions) applyCreate(input *s3.CreateMultipartUploadInput) {
+	if o == nil {
+		return
+	}
+	input.ServerSideEncryption = types.ServerSideEncryption(o.ServerSideEncryption)
+	input.SSEKMSKeyId = optionalString(o.SSEKMSKeyID)
+	input.StorageClass = types.StorageClass(o.StorageClass)
+	input.Tagging = optionalString(o.tagging())
+	input.ACL = types.ObjectCannedACL(o.ACL)
+	if o.ObjectLockMode != "" {
+		input.ObjectLockMode = types.ObjectLockMode(o.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(o.RetainUntil)
+	}
+}
+
+// applyCopy sets the options on a copy, the storage class, encryption and
+// ACL of the source are not kept by S3 so they are set again
+func (o *S3PutOptions) applyCopy(input *s3.CopyObjectInput) {
+	if o == nil {
+		return
+	}
+	input.ServerSideEncryption = types.ServerSideEncryption(o.ServerSideEncryption)
+	input.SSEKMSKeyId = optionalString(o.SSEKMSKeyID)
+	input.StorageClass = types.StorageClass(o.StorageClass)
+	input.ACL = types.ObjectCannedACL(o.ACL)
+	if len(o.Tags) > 0 {
+		input.Tagging = optionalString(o.tagging())
+		input.TaggingDirective = types.TaggingDirectiveReplace
+	}
+	if o.ObjectLockMode != "" {
+		input.ObjectLockMode = types.ObjectLockMode(o.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(o.RetainUntil)
+	}
+}
+
+// applyPart sets the options on one part of a multipart upload, S3 requires
+// a Content-MD5 for every part of an object under Object Lock
+func (o *S3PutOptions) applyPart(input *s3.UploadPartInput, part []byte) {
+	if o != nil && o.ObjectLockMode != "" {
+		input.ContentMD5 = contentMD5(part)
+	}
+}
+
+// tagging encodes the tags as the query string S3 expects
+func (o *S3PutOptions) tagging() string {
+	values := url.Values{}
+	for key, value := range o.Tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+func contentMD5(body []byte) *string {
+	sum := md5.Sum(body)
+	return aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
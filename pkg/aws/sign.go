@@ -0,0 +1,97 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/dathan/go-vault-dump/pkg/manifest"
+)
+
+// KMSSigner signs manifests with an asymmetric KMS key
+type KMSSigner struct {
+	Key string
+	// SigningAlgorithm defaults to the first SHA-256 algorithm the key
+	// supports, manifests are signed as SHA-256 digests
+	SigningAlgorithm string
+}
+
+func NewKMSSigner(keyID string) *KMSSigner {
+	return &KMSSigner{Key: keyID}
+}
+
+func (k *KMSSigner) Algorithm() string {
+	return k.SigningAlgorithm
+}
+
+func (k *KMSSigner) KeyID() string {
+	return k.Key
+}
+
+func (k *KMSSigner) Sign(digest []byte) ([]byte, error) {
+	kmssvc := NewKMSClientForKey(k.Key)
+	if k.SigningAlgorithm == "" {
+		pub, err := kmssvc.GetPublicKey(context.TODO(), &kms.GetPublicKeyInput{KeyId: aws.String(k.Key)})
+		if err != nil {
+			return nil, err
+		}
+		for _, alg := range pub.SigningAlgorithms {
+			if isSHA256Algorithm(string(alg)) {
+				k.SigningAlgorithm = string(alg)
+				break
+			}
+		}
+		if k.SigningAlgorithm == "" {
+			return nil, fmt.Errorf("KMS key %s cannot sign SHA-256 digests, it supports %v", k.Key, pub.SigningAlgorithms)
+		}
+	}
+	if !isSHA256Algorithm(k.SigningAlgorithm) {
+		return nil, fmt.Errorf("signing algorithm %s does not sign SHA-256 digests", k.SigningAlgorithm)
+	}
+
+	resp, err := kmssvc.Sign(context.TODO(), &kms.SignInput{
+		KeyId:            aws.String(k.Key),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpec(k.SigningAlgorithm),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// Verify checks a signature with KMS Verify against k.Key, whatever key the
+// signature names, so a manifest signed again with another key fails
+func (k *KMSSigner) Verify(sig *manifest.Signature, digest []byte) error {
+	if k.Key == "" {
+		return errors.New("no KMS key to verify the signature with")
+	}
+	if !isSHA256Algorithm(sig.Algorithm) {
+		return fmt.Errorf("unsupported signing algorithm %s", sig.Algorithm)
+	}
+	resp, err := NewKMSClientForKey(k.Key).Verify(context.TODO(), &kms.VerifyInput{
+		KeyId:            aws.String(k.Key),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		Signature:        sig.Value,
+		SigningAlgorithm: types.SigningAlgorithmSpec(sig.Algorithm),
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.SignatureValid {
+		return errors.New("KMS reports the signature is not valid")
+	}
+	return nil
+}
+
+// isSHA256Algorithm reports whether a KMS signing algorithm takes a SHA-256
+// digest, such as ECDSA_SHA_256 or RSASSA_PSS_SHA_256
+func isSHA256Algorithm(alg string) bool {
+	return strings.HasSuffix(alg, "_SHA_256")
+}
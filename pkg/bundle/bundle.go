@@ -0,0 +1,249 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/compress"
+)
+
+// Provider names recorded in stanzas
+const (
+	KMS        = "kms"
+	X25519     = "x25519"
+	Passphrase = "passphrase"
+	Transit    = "transit"
+	Shamir     = "shamir"
+
+	// Legacy is passed to a Resolver for bundles written before the envelope
+	// format existed, the returned provider must implement LegacyOpener
+	Legacy = "legacy"
+)
+
+// Stanza holds the data key of a bundle wrapped for one recipient
+type Stanza struct {
+	Provider string            `json:"provider"`
+	KeyID    string            `json:"key_id,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Wrapped  []byte            `json:"wrapped"`
+
+	// Context is the encryption context recorded in the bundle header, set
+	// when reading so providers can supply it when unwrapping
+	Context map[string]string `json:"-"`
+}
+
+// Provider wraps and unwraps bundle data keys
+type Provider interface {
+	// Name is the provider name recorded in stanzas
+	Name() string
+	// Wrap encrypts the data key for this provider's recipient
+	Wrap(key []byte) (*Stanza, error)
+	// Unwrap recovers the data key from a stanza written by Wrap
+	Unwrap(stanza *Stanza) ([]byte, error)
+}
+
+// KeyGenerator is implemented by providers that generate the data key
+// themselves, such as Vault Transit. When the first recipient implements it
+// the data key comes from GenerateKey instead of crypto/rand.
+type KeyGenerator interface {
+	GenerateKey(size int) ([]byte, *Stanza, error)
+}
+
+// LegacyOpener decrypts bundles written before the envelope format existed
+type LegacyOpener interface {
+	OpenLegacy(data []byte) ([]byte, error)
+}
+
+// Resolver returns the provider able to unwrap stanza, or an error if none
+// is configured for it
+type Resolver func(stanza *Stanza) (Provider, error)
+
+// Options configure NewWriter
+type Options struct {
+	Recipients  []Provider
+	Compression string
+	ChunkSize   int
+	// Context is recorded in the header, it must match the encryption
+	// context the recipients wrapped the data key with
+	Context map[string]string
+}
+
+// NewWriter returns a writer that compresses and encrypts everything written
+// to it into w, under a random data key wrapped for every recipient. Close
+// must be called to seal the final chunk, it does not close w.
+func NewWriter(w io.Writer, opts *Options) (io.WriteCloser, error) {
+	if err := compress.Validate(opts.Compression); err != nil {
+		return nil, err
+	}
+
+	key, stanzas, err := WrapKey(opts.Recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	env := &envelope{
+		version: envelopeVersion3,
+		header: envelopeHeader{
+			Cipher:      envelopeCipher,
+			ToolVersion: ToolVersion,
+			ChunkSize:   chunkSize,
+			Compression: opts.Compression,
+			Context:     opts.Context,
+		},
+		stanzas: stanzas,
+	}
+
+	encrypted, err := newChunkWriter(w, env, key)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := compress.NewWriter(encrypted, opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedWriter{compressed, encrypted}, nil
+}
+
+// Reader reads the plaintext of a bundle
+type Reader struct {
+	io.Reader
+	// Recipient is the stanza whose wrapped key was used to decrypt
+	Recipient *Stanza
+}
+
+// WrapKey generates a data key and wraps it for every recipient
+func WrapKey(recipients []Provider) ([]byte, []*Stanza, error) {
+	if len(recipients) == 0 {
+		return nil, nil, errors.New("at least one recipient is required")
+	}
+
+	key, stanzas, err := newDataKey(recipients[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, pp := range recipients[len(stanzas):] {
+		stanza, err := pp.Wrap(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap data key with %s: %w", pp.Name(), err)
+		}
+		stanzas = append(stanzas, stanza)
+	}
+	return key, stanzas, nil
+}
+
+// newDataKey generates the data key, returning the stanza for recipient when
+// it generated the key itself
+func newDataKey(recipient Provider) ([]byte, []*Stanza, error) {
+	if kg, ok := recipient.(KeyGenerator); ok {
+		key, stanza, err := kg.GenerateKey(envelopeKeySize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate data key with %s: %w", recipient.Name(), err)
+		}
+		if len(key) != envelopeKeySize {
+			return nil, nil, fmt.Errorf("%s generated a data key of unexpected size", recipient.Name())
+		}
+		return key, []*Stanza{stanza}, nil
+	}
+
+	key := make([]byte, envelopeKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	return key, []*Stanza{}, nil
+}
+
+// NewReader returns a reader over the decrypted and decompressed contents of
+// the bundle read from r. The provider for each stanza is looked up with
+// resolve, the first one that unwraps the data key is used.
+func NewReader(r io.Reader, resolve Resolver) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(envelopeMagic))
+	if !IsEnvelope(magic) {
+		plaintext, err := openLegacy(br, resolve)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{plaintext, &Stanza{Provider: Legacy}}, nil
+	}
+
+	env, err := readEnvelope(br)
+	if err != nil {
+		return nil, err
+	}
+
+	key, stanza, err := UnwrapKey(env.stanzas, resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := env.open(br, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{plaintext, stanza}, nil
+}
+
+// String describes the recipient used to decrypt, eg "kms arn:aws:kms:..."
+func (r *Reader) String() string {
+	return r.Recipient.String()
+}
+
+// UnwrapKey tries every stanza in turn until one yields the data key,
+// returning the key and the stanza it was unwrapped from
+func UnwrapKey(stanzas []*Stanza, resolve Resolver) ([]byte, *Stanza, error) {
+	failures := make([]string, 0, len(stanzas))
+	for _, stanza := range stanzas {
+		pp, err := resolve(stanza)
+		if err == nil {
+			var key []byte
+			key, err = pp.Unwrap(stanza)
+			if err == nil && len(key) != envelopeKeySize {
+				err = errors.New("unwrapped data key has unexpected size")
+			}
+			if err == nil {
+				return key, stanza, nil
+			}
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", stanza, err))
+	}
+	return nil, nil, fmt.Errorf("unable to unwrap data key (%s)", strings.Join(failures, "; "))
+}
+
+func openLegacy(r io.Reader, resolve Resolver) (io.Reader, error) {
+	pp, err := resolve(&Stanza{Provider: Legacy})
+	if err != nil {
+		return nil, err
+	}
+	lo, ok := pp.(LegacyOpener)
+	if !ok {
+		return nil, errors.New("not a vault-dump envelope")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := lo.OpenLegacy(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// String names the provider and key of a stanza
+func (s *Stanza) String() string {
+	if s.KeyID == "" {
+		return s.Provider
+	}
+	return fmt.Sprintf("%s %s", s.Provider, s.KeyID)
+}
@@ -0,0 +1,200 @@
+package bundle
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/compress"
+)
+
+// staticProvider stands in for KMS, wrapping is the identity function
+type staticProvider struct{ keyID string }
+
+func (staticProvider) Name() string { return KMS }
+func (s staticProvider) Wrap(key []byte) (*Stanza, error) {
+	if s.keyID == "" {
+		s.keyID = "arn:test"
+	}
+	return &Stanza{Provider: KMS, KeyID: s.keyID, Wrapped: key}, nil
+}
+func (staticProvider) Unwrap(stanza *Stanza) ([]byte, error) {
+	if stanza.KeyID == "arn:deleted" {
+		return nil, errors.New("key is pending deletion")
+	}
+	if stanza.KeyID == "arn:context" && stanza.Context["app"] != "vault-dump" {
+		return nil, errors.New("encryption context mismatch")
+	}
+	return stanza.Wrapped, nil
+}
+
+// generatorProvider generates the data key itself, like Vault Transit
+type generatorProvider struct{ staticProvider }
+
+func (generatorProvider) GenerateKey(size int) ([]byte, *Stanza, error) {
+	key := bytes.Repeat([]byte{5}, size)
+	return key, &Stanza{Provider: KMS, KeyID: "arn:generated", Wrapped: key}, nil
+}
+
+// paramsProvider overrides parameters of the stanzas of a provider, like a
+// crafted bundle
+type paramsProvider struct {
+	Provider
+	params map[string]string
+}
+
+func (p paramsProvider) Wrap(key []byte) (*Stanza, error) {
+	stanza, err := p.Provider.Wrap(key)
+	if err != nil {
+		return nil, err
+	}
+	for kk, vv := range p.params {
+		stanza.Params[kk] = vv
+	}
+	return stanza, nil
+}
+
+func TestSuiteEnvelope(tt *testing.T) {
+
+	key := bytes.Repeat([]byte{7}, 32)
+	seal := func(plaintext string, opts *Options) []byte {
+		var buf bytes.Buffer
+		ww, err := NewWriter(&buf, opts)
+		if err != nil {
+			tt.Fatalf("FAIL seal: %s", err)
+		}
+		ww.Write([]byte(plaintext))
+		ww.Close()
+		return buf.Bytes()
+	}
+	static := []Provider{staticProvider{}}
+	several := []Provider{staticProvider{"arn:deleted"}, staticProvider{"arn:other-region"}}
+
+	sealed := seal("This is a test!", &Options{Recipients: static, ChunkSize: 4})
+	tampered := append([]byte{}, sealed...)
+	tampered[len(envelopeMagic)+1+envelopeFieldSize+2] ^= 1 // flip a byte of the header
+	finalChunk := 4 + 3 + 16                                // length prefix, 3 bytes of plaintext and the tag
+
+	// version 1 envelopes hold a KMS wrapped key and the payload as a single GCM message
+	var v1 bytes.Buffer
+	v1.Write(envelopeMagic)
+	v1.WriteByte(envelopeVersion1)
+	hh, _ := json.Marshal(envelopeHeader{Cipher: envelopeCipher, KMSKeyID: "arn:test", ToolVersion: "test"})
+	writeField(&v1, hh)
+	aad := append([]byte{}, v1.Bytes()...)
+	nonce := bytes.Repeat([]byte{1}, 12)
+	gcm, _ := newGCM(key)
+	writeField(&v1, key)
+	writeField(&v1, nonce)
+	writeField(&v1, gcm.Seal(nil, nonce, []byte("This is a test!"), aad))
+
+	// version 2 envelopes hold a KMS wrapped key and a chunked payload
+	var v2 bytes.Buffer
+	ww, _ := newChunkWriter(&v2, &envelope{
+		version: envelopeVersion2,
+		header:  envelopeHeader{Cipher: envelopeCipher, KMSKeyID: "arn:test", ToolVersion: "test", ChunkSize: 4},
+		stanzas: []*Stanza{{Wrapped: key}},
+	}, key)
+	ww.Write([]byte("This is a test!"))
+	ww.Close()
+
+	identity, recipient, _ := GenerateX25519Identity()
+	otherIdentity, _, _ := GenerateX25519Identity()
+	toRecipient, _ := NewX25519Recipient(recipient)
+	passphrase, _ := NewPassphrase("correct horse", Scrypt)
+	hugeR := paramsProvider{passphrase, map[string]string{"r": "1048576"}}
+	hugeP := paramsProvider{passphrase, map[string]string{"p": "65536"}}
+
+	split, _ := NewShamirSplit(5, 3)
+	otherSplit, _ := NewShamirSplit(5, 3)
+	shares := split.Shares()
+	typo := []byte(shares[1])
+	typo[len(sharePrefix)+12] ^= 1
+	shareSets := map[string][]string{
+		"threshold": {shares[4], strings.ToLower(shares[0]), shares[2]},
+		"below":     shares[:2],
+		"other":     otherSplit.Shares()[:3],
+		"mixed":     {shares[0], shares[1], otherSplit.Shares()[2]},
+		"typo":      {shares[0], string(typo), shares[2]},
+	}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []byte
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Open envelope", "Static", sealed, "This is a test!", true},
+			{"Open single chunk envelope", "Static", seal("This is a test!", &Options{Recipients: static}), "This is a test!", true},
+			{"Open empty envelope", "Static", seal("", &Options{Recipients: static, ChunkSize: 4}), "", true},
+			{"Open envelope of whole chunks", "Static", seal("12345678", &Options{Recipients: static, ChunkSize: 4}), "12345678", true},
+			{"Open gzip envelope", "Static", seal("This is a test!", &Options{Recipients: static, ChunkSize: 4, Compression: compress.Gzip}), "This is a test!", true},
+			{"Open zstd envelope", "Static", seal("This is a test!", &Options{Recipients: static, ChunkSize: 4, Compression: compress.Zstd}), "This is a test!", true},
+			{"Open version 1 envelope", "Static", v1.Bytes(), "This is a test!", true},
+			{"Open version 2 envelope", "Static", v2.Bytes(), "This is a test!", true},
+			{"Open envelope wrapped for several keys", "Report", seal("This is a test!", &Options{Recipients: several}), "This is a test! (kms arn:other-region)", true},
+			{"Open envelope with generated data key", "Report", seal("This is a test!", &Options{Recipients: []Provider{generatorProvider{}, staticProvider{}}}), "This is a test! (kms arn:generated)", true},
+			{"Open envelope wrapped for several deleted keys", "Static", seal("This is a test!", &Options{Recipients: several[:1]}), "", false},
+			{"Open envelope with encryption context", "Static", seal("This is a test!", &Options{Recipients: []Provider{staticProvider{"arn:context"}}, Context: map[string]string{"app": "vault-dump"}}), "This is a test!", true},
+			{"Open envelope without encryption context", "Static", seal("This is a test!", &Options{Recipients: []Provider{staticProvider{"arn:context"}}}), "", false},
+			{"Open without provider", "None", sealed, "", false},
+			{"Open tampered header", "Static", tampered, "", false},
+			{"Open truncated chunk", "Static", sealed[:len(sealed)-3], "", false},
+			{"Open without final chunk", "Static", sealed[:len(sealed)-finalChunk], "", false},
+			{"Open with trailing data", "Static", append(append([]byte{}, sealed...), 0), "", false},
+			{"Open legacy bundle without legacy provider", "Static", []byte("AAEAAQ=="), "", false},
+			{"Open X25519 envelope", "X25519:" + identity, seal("This is a test!", &Options{Recipients: []Provider{toRecipient}}), "This is a test!", true},
+			{"Open X25519 envelope with other identity", "X25519:" + otherIdentity, seal("This is a test!", &Options{Recipients: []Provider{toRecipient}}), "", false},
+			{"Open passphrase envelope", "Passphrase:correct horse", seal("This is a test!", &Options{Recipients: []Provider{passphrase}}), "This is a test!", true},
+			{"Open passphrase envelope with wrong passphrase", "Passphrase:battery staple", seal("This is a test!", &Options{Recipients: []Provider{passphrase}}), "", false},
+			{"Open passphrase envelope with huge scrypt r", "Passphrase:correct horse", seal("This is a test!", &Options{Recipients: []Provider{hugeR}}), "", false},
+			{"Open passphrase envelope with huge scrypt p", "Passphrase:correct horse", seal("This is a test!", &Options{Recipients: []Provider{hugeP}}), "", false},
+			{"Open shamir envelope with threshold shares", "Shamir:threshold", seal("This is a test!", &Options{Recipients: []Provider{split}}), "This is a test!", true},
+			{"Open shamir envelope below threshold", "Shamir:below", seal("This is a test!", &Options{Recipients: []Provider{split}}), "", false},
+			{"Open shamir envelope with shares of another set", "Shamir:other", seal("This is a test!", &Options{Recipients: []Provider{split}}), "", false},
+			{"Open shamir envelope with mixed share sets", "Shamir:mixed", seal("This is a test!", &Options{Recipients: []Provider{split}}), "", false},
+			{"Open shamir envelope with mistyped share", "Shamir:typo", seal("This is a test!", &Options{Recipients: []Provider{split}}), "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		resolve := func(stanza *Stanza) (Provider, error) {
+			switch {
+			case test.action == "Static" || test.action == "Report":
+				return staticProvider{}, nil
+			case len(test.action) > 7 && test.action[:7] == "X25519:":
+				return NewX25519Identity(test.action[7:])
+			case len(test.action) > 11 && test.action[:11] == "Passphrase:":
+				return NewPassphrase(test.action[11:], "")
+			case len(test.action) > 7 && test.action[:7] == "Shamir:":
+				return NewShamirShares(shareSets[test.action[7:]])
+			}
+			return nil, errors.New("no provider")
+		}
+		pr, err := NewReader(bytes.NewReader(test.inputs), resolve)
+		success = (err == nil)
+		if success {
+			out, err := ioutil.ReadAll(pr)
+			success = (err == nil)
+			norm = string(out)
+			if test.action == "Report" {
+				norm += " (" + pr.String() + ")"
+			}
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/dathan/go-vault-dump/pkg/compress"
+)
+
+// Envelope layout, all fields are prefixed with their length as a big endian
+// uint32:
+//
+//	version 1: magic | version | header | wrapped data key | nonce | ciphertext
+//	version 2: magic | version | header | wrapped data key | nonce prefix | chunk...
+//	version 3: magic | version | header | stanzas | nonce prefix | chunk...
+//
+// The magic, version and header bytes are passed to AES-GCM as additional
+// authenticated data, so tampering with the recorded cipher, compression or
+// tool version is detected on decrypt. Versions 2 and 3 split the payload
+// into chunks of at most ChunkSize bytes, see stream.go.
+//
+// Version 3 replaces the single KMS wrapped key with a JSON list of stanzas,
+// one per recipient, see Provider. Stanzas are deliberately left out of the
+// authenticated data: each one is authenticated by the provider that wrapped
+// it, and keeping them separate allows the data key to be re-wrapped without
+// re-encrypting the payload. Only version 3 is written, older versions are
+// still read.
+const (
+	envelopeVersion1  byte = 1
+	envelopeVersion2  byte = 2
+	envelopeVersion3  byte = 3
+	envelopeCipher         = "AES-256-GCM"
+	envelopeKeySize        = 32
+	envelopeMaxField       = 1 << 30
+	envelopeMaxHeader      = 1 << 20
+	envelopeFieldSize      = 4
+)
+
+var (
+	envelopeMagic = []byte{0x89, 'V', 'D', 'B'}
+
+	// ToolVersion is recorded in the header of every envelope
+	ToolVersion = "dev"
+)
+
+type envelopeHeader struct {
+	Cipher      string `json:"cipher"`
+	KMSKeyID    string `json:"kms_key_id,omitempty"` // versions 1 and 2 only
+	ToolVersion string `json:"tool_version"`
+	ChunkSize   int    `json:"chunk_size,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	// Context is the encryption context bound to KMS wrapped keys
+	Context map[string]string `json:"context,omitempty"`
+}
+
+// envelope holds everything preceding the encrypted payload
+type envelope struct {
+	version byte
+	header  envelopeHeader
+	aad     []byte
+	stanzas []*Stanza
+	nonce   []byte
+}
+
+// IsEnvelope reports whether data starts with the envelope magic, legacy
+// bundles are base64 encoded and can never match it
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+func writeField(w io.Writer, field []byte) error {
+	var size [envelopeFieldSize]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(field)
+	return err
+}
+
+// readField reads one length prefixed field of at most limit bytes
+func readField(r io.Reader, limit int) ([]byte, error) {
+	var size [envelopeFieldSize]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, errors.New("truncated envelope")
+	}
+	nn := binary.BigEndian.Uint32(size[:])
+	if int64(nn) > int64(limit) {
+		return nil, errors.New("envelope field exceeds payload")
+	}
+	field := make([]byte, nn)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, errors.New("truncated envelope")
+	}
+	return field, nil
+}
+
+// writeEnvelope writes the envelope preamble to w and records the
+// additional authenticated data in env
+func writeEnvelope(w io.Writer, env *envelope) error {
+	hh, err := json.Marshal(env.header)
+	if err != nil {
+		return err
+	}
+	var ss []byte
+	if env.version == envelopeVersion3 {
+		if ss, err = json.Marshal(env.stanzas); err != nil {
+			return err
+		}
+	} else if len(env.stanzas) == 1 {
+		ss = env.stanzas[0].Wrapped
+	} else {
+		return fmt.Errorf("envelope version %d holds exactly one wrapped key", env.version)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(envelopeMagic)
+	buf.WriteByte(env.version)
+	writeField(&buf, hh)
+	env.aad = append([]byte{}, buf.Bytes()...)
+
+	writeField(&buf, ss)
+	writeField(&buf, env.nonce)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// readEnvelope reads the envelope preamble from r, leaving r positioned at
+// the start of the encrypted payload
+func readEnvelope(r io.Reader) (*envelope, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, errors.New("truncated envelope")
+	}
+	if !IsEnvelope(prefix[:]) {
+		return nil, errors.New("not a vault-dump envelope")
+	}
+
+	env := &envelope{version: prefix[len(envelopeMagic)]}
+	if env.version < envelopeVersion1 || env.version > envelopeVersion3 {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.version)
+	}
+
+	hh, err := readField(r, envelopeMaxHeader)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(hh, &env.header); err != nil {
+		return nil, fmt.Errorf("invalid envelope header: %w", err)
+	}
+	if env.header.Cipher != envelopeCipher {
+		return nil, fmt.Errorf("unsupported envelope cipher %q", env.header.Cipher)
+	}
+
+	var aad bytes.Buffer
+	aad.Write(prefix[:])
+	writeField(&aad, hh)
+	env.aad = aad.Bytes()
+
+	keys, err := readField(r, envelopeMaxHeader)
+	if err != nil {
+		return nil, err
+	}
+	if env.version == envelopeVersion3 {
+		if err := json.Unmarshal(keys, &env.stanzas); err != nil {
+			return nil, fmt.Errorf("invalid envelope stanzas: %w", err)
+		}
+	} else {
+		// earlier versions could only be wrapped by a single KMS key
+		env.stanzas = []*Stanza{{Provider: KMS, KeyID: env.header.KMSKeyID, Wrapped: keys}}
+	}
+	if len(env.stanzas) == 0 {
+		return nil, errors.New("envelope has no recipients")
+	}
+	for _, stanza := range env.stanzas {
+		stanza.Context = env.header.Context
+	}
+
+	if env.nonce, err = readField(r, envelopeMaxHeader); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// decrypt returns a reader over the plaintext of the payload following the
+// preamble in r, key is the unwrapped data key
+func (env *envelope) decrypt(r io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.version != envelopeVersion1 {
+		return newChunkReader(r, gcm, env)
+	}
+
+	if len(env.nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid envelope nonce")
+	}
+	ciphertext, err := readField(r, envelopeMaxField)
+	if err != nil {
+		return nil, err
+	}
+	if trailing, _ := ioutil.ReadAll(r); len(trailing) != 0 {
+		return nil, errors.New("trailing data after envelope")
+	}
+	plaintext, err := gcm.Open(nil, env.nonce, ciphertext, env.aad)
+	if err != nil {
+		return nil, errors.New("envelope authentication failed")
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// compressedWriter flushes the compressor before sealing the envelope
+type compressedWriter struct {
+	io.WriteCloser
+	envelope io.WriteCloser
+}
+
+func (c *compressedWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return c.envelope.Close()
+}
+
+// open decrypts and decompresses the payload following the preamble in r
+func (env *envelope) open(r io.Reader, key []byte) (io.Reader, error) {
+	plaintext, err := env.decrypt(r, key)
+	if err != nil {
+		return nil, err
+	}
+	return compress.NewReader(plaintext, env.header.Compression)
+}
+
+// Info describes a bundle from its unencrypted header
+type Info struct {
+	Version     int
+	Cipher      string
+	ToolVersion string
+	Compression string
+	Context     map[string]string
+	Recipients  []*Stanza
+}
+
+// ReadInfo reads the header at the start of a bundle without decrypting it,
+// legacy bundles are reported as version 0
+func ReadInfo(r io.Reader) (*Info, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(envelopeMagic))
+	if !IsEnvelope(magic) {
+		return &Info{Cipher: "AES-256-CBC", Recipients: []*Stanza{{Provider: KMS}}}, nil
+	}
+	env, err := readEnvelope(br)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Version:     int(env.version),
+		Cipher:      env.header.Cipher,
+		ToolVersion: env.header.ToolVersion,
+		Compression: env.header.Compression,
+		Context:     env.header.Context,
+		Recipients:  env.stanzas,
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,131 @@
+package bundle
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions accepted by NewPassphrase
+const (
+	Argon2id = "argon2id"
+	Scrypt   = "scrypt"
+)
+
+// work factors used when wrapping, and the largest ones accepted when
+// unwrapping so a crafted bundle cannot exhaust memory
+const (
+	argon2Time       = 3
+	argon2Memory     = 64 * 1024 // KiB
+	argon2Threads    = 4
+	argon2MaxMemory  = 4 * 1024 * 1024
+	scryptLogN       = 18
+	scryptR          = 8
+	scryptP          = 1
+	scryptMaxLogN    = 22
+	scryptMaxR       = 32
+	scryptMaxP       = 16
+	passphraseSaltSz = 16
+)
+
+type passphraseProvider struct {
+	passphrase []byte
+	kdf        string
+}
+
+// NewPassphrase returns a provider wrapping data keys under a key derived
+// from passphrase with kdf, argon2id when kdf is empty
+func NewPassphrase(passphrase string, kdf string) (Provider, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	if kdf == "" {
+		kdf = Argon2id
+	}
+	if kdf != Argon2id && kdf != Scrypt {
+		return nil, fmt.Errorf("unsupported key derivation %q, we only accept: %v", kdf, []string{Argon2id, Scrypt})
+	}
+	return &passphraseProvider{passphrase: []byte(passphrase), kdf: kdf}, nil
+}
+
+func (p *passphraseProvider) Name() string {
+	return Passphrase
+}
+
+func (p *passphraseProvider) Wrap(key []byte) (*Stanza, error) {
+	salt := make([]byte, passphraseSaltSz)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{
+		"kdf":  p.kdf,
+		"salt": base64.RawURLEncoding.EncodeToString(salt),
+	}
+	if p.kdf == Scrypt {
+		params["log_n"] = strconv.Itoa(scryptLogN)
+		params["r"] = strconv.Itoa(scryptR)
+		params["p"] = strconv.Itoa(scryptP)
+	} else {
+		params["t"] = strconv.Itoa(argon2Time)
+		params["m"] = strconv.Itoa(argon2Memory)
+		params["p"] = strconv.Itoa(argon2Threads)
+	}
+
+	kek, err := p.derive(params)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := sealKey(kek, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Provider: Passphrase, Params: params, Wrapped: wrapped}, nil
+}
+
+func (p *passphraseProvider) Unwrap(stanza *Stanza) ([]byte, error) {
+	kek, err := p.derive(stanza.Params)
+	if err != nil {
+		return nil, err
+	}
+	key, err := openKey(kek, stanza.Wrapped)
+	if err != nil {
+		return nil, errors.New("incorrect passphrase")
+	}
+	return key, nil
+}
+
+// derive computes the key encryption key from the parameters of a stanza
+func (p *passphraseProvider) derive(params map[string]string) ([]byte, error) {
+	salt, err := base64.RawURLEncoding.DecodeString(params["salt"])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("invalid passphrase stanza salt")
+	}
+
+	switch params["kdf"] {
+	case Scrypt:
+		logN, err1 := strconv.Atoi(params["log_n"])
+		r, err2 := strconv.Atoi(params["r"])
+		pp, err3 := strconv.Atoi(params["p"])
+		if err1 != nil || err2 != nil || err3 != nil || logN < 1 || logN > scryptMaxLogN ||
+			r < 1 || r > scryptMaxR || pp < 1 || pp > scryptMaxP || 128*r<<logN > argon2MaxMemory*1024 {
+			return nil, errors.New("invalid scrypt parameters")
+		}
+		return scrypt.Key(p.passphrase, salt, 1<<logN, r, pp, chacha20poly1305.KeySize)
+	case Argon2id:
+		t, err1 := strconv.Atoi(params["t"])
+		m, err2 := strconv.Atoi(params["m"])
+		pp, err3 := strconv.Atoi(params["p"])
+		if err1 != nil || err2 != nil || err3 != nil || t < 1 || t > 64 || m < 8 || m > argon2MaxMemory || pp < 1 || pp > 255 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		return argon2.IDKey(p.passphrase, salt, uint32(t), uint32(m), uint8(pp), chacha20poly1305.KeySize), nil
+	}
+	return nil, fmt.Errorf("unsupported key derivation %q", params["kdf"])
+}
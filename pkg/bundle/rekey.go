@@ -0,0 +1,120 @@
+package bundle
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// RekeyOptions configure Rekey
+type RekeyOptions struct {
+	// Recipients returns the providers to wrap the data key for, bound to
+	// the encryption context recorded in the bundle
+	Recipients func(context map[string]string) ([]Provider, error)
+	// LegacyContext is recorded in legacy bundles when they are encrypted
+	// again, they have no context of their own
+	LegacyContext map[string]string
+}
+
+// Rekey copies the bundle read from r to w with its data key wrapped for new
+// recipients in place of the current ones. The data key is unwrapped with
+// resolve. The encryption context recorded in the bundle is kept.
+//
+// Version 3 envelopes keep their encrypted payload byte for byte, as the
+// stanzas are not part of the authenticated data; the payload is still
+// decrypted while it is copied, so a bundle is never re-keyed unless the
+// data key opens it. Older envelopes and legacy bundles bind the wrapped key
+// to the payload, they are decrypted and encrypted again as version 3 under
+// a new data key. Rekey reports whether the payload was encrypted again.
+func Rekey(r io.Reader, w io.Writer, resolve Resolver, opts *RekeyOptions) (bool, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(envelopeMagic))
+	if !IsEnvelope(magic) {
+		plaintext, err := openLegacy(br, resolve)
+		if err != nil {
+			return false, err
+		}
+		return true, reencrypt(plaintext, w, "", opts.LegacyContext, opts)
+	}
+
+	env, err := readEnvelope(br)
+	if err != nil {
+		return false, err
+	}
+	key, _, err := UnwrapKey(env.stanzas, resolve)
+	if err != nil {
+		return false, err
+	}
+
+	if env.version != envelopeVersion3 {
+		plaintext, err := env.open(br, key)
+		if err != nil {
+			return false, err
+		}
+		return true, reencrypt(plaintext, w, env.header.Compression, env.header.Context, opts)
+	}
+
+	providers, err := opts.Recipients(env.header.Context)
+	if err != nil {
+		return false, err
+	}
+	if len(providers) == 0 {
+		return false, errors.New("at least one recipient is required")
+	}
+	stanzas := make([]*Stanza, 0, len(providers))
+	for _, pp := range providers {
+		stanza, err := pp.Wrap(key)
+		if err != nil {
+			return false, fmt.Errorf("failed to wrap data key with %s: %w", pp.Name(), err)
+		}
+		stanzas = append(stanzas, stanza)
+	}
+	ss, err := json.Marshal(stanzas)
+	if err != nil {
+		return false, err
+	}
+
+	// the authenticated preamble is copied as read, the header is not
+	// encoded again
+	if _, err := w.Write(env.aad); err != nil {
+		return false, err
+	}
+	if err := writeField(w, ss); err != nil {
+		return false, err
+	}
+	if err := writeField(w, env.nonce); err != nil {
+		return false, err
+	}
+
+	plaintext, err := env.decrypt(io.TeeReader(br, w), key)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(ioutil.Discard, plaintext); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// reencrypt compresses and encrypts plaintext into w under a new data key
+func reencrypt(plaintext io.Reader, w io.Writer, compression string, context map[string]string, opts *RekeyOptions) error {
+	providers, err := opts.Recipients(context)
+	if err != nil {
+		return err
+	}
+	ww, err := NewWriter(w, &Options{
+		Recipients:  providers,
+		Compression: compression,
+		Context:     context,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ww, plaintext); err != nil {
+		return err
+	}
+	return ww.Close()
+}
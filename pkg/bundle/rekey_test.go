@@ -0,0 +1,148 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/compress"
+)
+
+// legacyProvider opens legacy bundles, their plaintext is the bundle itself
+type legacyProvider struct{ staticProvider }
+
+func (legacyProvider) OpenLegacy(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func TestSuiteRekey(tt *testing.T) {
+
+	identity, recipient, _ := GenerateX25519Identity()
+	toRecipient, _ := NewX25519Recipient(recipient)
+	context := map[string]string{"app": "vault-dump"}
+
+	seal := func(plaintext string, opts *Options) []byte {
+		var buf bytes.Buffer
+		ww, err := NewWriter(&buf, opts)
+		if err != nil {
+			tt.Fatalf("FAIL seal: %s", err)
+		}
+		ww.Write([]byte(plaintext))
+		ww.Close()
+		return buf.Bytes()
+	}
+	sealed := seal("This is a test!", &Options{Recipients: []Provider{staticProvider{"arn:context"}}, ChunkSize: 4, Context: context})
+	zstd := seal("This is a test!", &Options{Recipients: []Provider{staticProvider{}}, Compression: compress.Zstd})
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1 // flip a byte of the final tag
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var v2 bytes.Buffer
+	ww, _ := newChunkWriter(&v2, &envelope{
+		version: envelopeVersion2,
+		header:  envelopeHeader{Cipher: envelopeCipher, KMSKeyID: "arn:test", ToolVersion: "test", ChunkSize: 4, Compression: compress.Gzip},
+		stanzas: []*Stanza{{Wrapped: key}},
+	}, key)
+	gz, _ := compress.NewWriter(ww, compress.Gzip)
+	gz.Write([]byte("This is a test!"))
+	gz.Close()
+	ww.Close()
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []byte
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Rekey envelope", "X25519", sealed, "This is a test! (x25519 kept)", true},
+			{"Rekey compressed envelope", "X25519", zstd, "This is a test! (x25519 kept)", true},
+			{"Rekey version 2 envelope", "X25519", v2.Bytes(), "This is a test! (x25519 encrypted again)", true},
+			{"Rekey legacy bundle", "X25519", []byte("This is a test!"), "This is a test! (x25519 encrypted again)", true},
+			{"Rekey for several recipients", "Several", sealed, "This is a test! (kms arn:new, x25519 kept)", true},
+			{"Rekey without recipients", "None", sealed, "", false},
+			{"Rekey without provider", "Unresolved", sealed, "", false},
+			{"Rekey tampered envelope", "X25519", tampered, "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		resolve := func(stanza *Stanza) (Provider, error) {
+			if test.action == "Unresolved" {
+				return nil, errors.New("no provider")
+			}
+			return legacyProvider{}, nil
+		}
+		opts := &RekeyOptions{Recipients: func(recorded map[string]string) ([]Provider, error) {
+			switch test.action {
+			case "X25519":
+				return []Provider{toRecipient}, nil
+			case "Several":
+				return []Provider{staticProvider{"arn:new"}, toRecipient}, nil
+			}
+			return nil, nil
+		}}
+
+		var out bytes.Buffer
+		reencrypted, err := Rekey(bytes.NewReader(test.inputs), &out, resolve, opts)
+		success = (err == nil)
+		if success {
+			var info *Info
+			info, err = ReadInfo(bytes.NewReader(out.Bytes()))
+			if err == nil && !reencrypted && !bytes.Equal(payload(out.Bytes()), payload(test.inputs)) {
+				err = errors.New("payload changed")
+			}
+			var pr *Reader
+			if err == nil {
+				pr, err = NewReader(bytes.NewReader(out.Bytes()), func(stanza *Stanza) (Provider, error) {
+					return NewX25519Identity(identity)
+				})
+			}
+			var plaintext []byte
+			if err == nil {
+				plaintext, err = ioutil.ReadAll(pr)
+			}
+			success = (err == nil)
+			norm = string(plaintext) + " ("
+			for ii := 0; success && ii < len(info.Recipients); ii++ {
+				stanza := info.Recipients[ii]
+				if ii > 0 {
+					norm += ", "
+				}
+				norm += stanza.Provider
+				if stanza.Provider == KMS {
+					norm += " " + stanza.KeyID
+				}
+			}
+			if reencrypted {
+				norm += " encrypted again)"
+			} else {
+				norm += " kept)"
+			}
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
+
+// payload returns the header, nonce and chunks of a version 3 envelope, the
+// bytes Rekey must leave untouched
+func payload(data []byte) []byte {
+	br := bytes.NewReader(data)
+	env, err := readEnvelope(br)
+	if err != nil || env.version != envelopeVersion3 {
+		return nil
+	}
+	rest, _ := ioutil.ReadAll(br)
+	return append(append(append([]byte{}, env.aad...), env.nonce...), rest...)
+}
@@ -0,0 +1,162 @@
+package bundle
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/shamir"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sharePrefix starts every share, shares only use characters of the QR
+// alphanumeric mode so they can be printed as compact QR codes or copied
+// by hand
+const sharePrefix = "VAULT-DUMP-SHARE-1"
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ShamirProvider wraps data keys under a random key split into shares, any
+// threshold of which recover it
+type ShamirProvider struct {
+	setID     string
+	threshold int
+	kek       []byte
+	shares    []string
+}
+
+// NewShamirSplit generates a key encryption key and splits it into parts
+// shares, threshold of them are needed to decrypt
+func NewShamirSplit(parts int, threshold int) (*ShamirProvider, error) {
+	kek := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(kek); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 5)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	split, err := shamir.Split(kek, parts, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShamirProvider{
+		setID:     shareEncoding.EncodeToString(id),
+		threshold: threshold,
+		kek:       kek,
+	}
+	for _, share := range split {
+		s.shares = append(s.shares, s.encodeShare(share))
+	}
+	return s, nil
+}
+
+// NewShamirShares combines shares written by NewShamirSplit, at least the
+// threshold recorded in them are required
+func NewShamirShares(shares []string) (*ShamirProvider, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares given")
+	}
+
+	var (
+		s     *ShamirProvider
+		split [][]byte
+	)
+	for ii, text := range shares {
+		setID, threshold, share, err := decodeShare(text)
+		if err != nil {
+			return nil, fmt.Errorf("share %d: %w", ii+1, err)
+		}
+		if s == nil {
+			s = &ShamirProvider{setID: setID, threshold: threshold}
+		} else if setID != s.setID || threshold != s.threshold {
+			return nil, fmt.Errorf("share %d belongs to share set %s, not %s", ii+1, setID, s.setID)
+		}
+		split = append(split, share)
+	}
+	if len(split) < s.threshold {
+		return nil, fmt.Errorf("share set %s needs %d shares, only %d given", s.setID, s.threshold, len(split))
+	}
+
+	kek, err := shamir.Combine(split)
+	if err != nil {
+		return nil, err
+	}
+	s.kek = kek
+	return s, nil
+}
+
+// Shares returns the shares of a key created by NewShamirSplit
+func (s *ShamirProvider) Shares() []string {
+	return s.shares
+}
+
+// SetID identifies the shares belonging to the same key
+func (s *ShamirProvider) SetID() string {
+	return s.setID
+}
+
+func (s *ShamirProvider) Name() string {
+	return Shamir
+}
+
+func (s *ShamirProvider) Wrap(key []byte) (*Stanza, error) {
+	wrapped, err := sealKey(s.kek, key)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{"threshold": strconv.Itoa(s.threshold)}
+	if len(s.shares) > 0 {
+		params["shares"] = strconv.Itoa(len(s.shares))
+	}
+	return &Stanza{Provider: Shamir, KeyID: s.setID, Params: params, Wrapped: wrapped}, nil
+}
+
+func (s *ShamirProvider) Unwrap(stanza *Stanza) ([]byte, error) {
+	if stanza.KeyID != s.setID {
+		return nil, fmt.Errorf("bundle needs shares from set %s, got set %s", stanza.KeyID, s.setID)
+	}
+	key, err := openKey(s.kek, stanza.Wrapped)
+	if err != nil {
+		return nil, errors.New("shares do not recover the data key")
+	}
+	return key, nil
+}
+
+// encodeShare formats a share as prefix:set:threshold:data:checksum, the
+// checksum catches shares mistyped when copied by hand
+func (s *ShamirProvider) encodeShare(share []byte) string {
+	body := fmt.Sprintf("%s:%s:%d:%s", sharePrefix, s.setID, s.threshold, shareEncoding.EncodeToString(share))
+	return body + ":" + shareChecksum(body)
+}
+
+func decodeShare(text string) (string, int, []byte, error) {
+	text = strings.ToUpper(strings.Join(strings.Fields(text), ""))
+	fields := strings.Split(text, ":")
+	if len(fields) != 5 || fields[0] != sharePrefix {
+		return "", 0, nil, errors.New("not a vault-dump share")
+	}
+	body := strings.Join(fields[:4], ":")
+	if fields[4] != shareChecksum(body) {
+		return "", 0, nil, errors.New("share checksum mismatch, it was copied incorrectly")
+	}
+	threshold, err := strconv.Atoi(fields[2])
+	if err != nil || threshold < 2 {
+		return "", 0, nil, errors.New("invalid share threshold")
+	}
+	share, err := shareEncoding.DecodeString(fields[3])
+	if err != nil || len(share) != chacha20poly1305.KeySize+1 {
+		return "", 0, nil, errors.New("invalid share data")
+	}
+	return fields[1], threshold, share, nil
+}
+
+func shareChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return shareEncoding.EncodeToString(sum[:5])
+}
@@ -0,0 +1,197 @@
+package bundle
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Version 2 and 3 envelopes carry the payload as a sequence of length prefixed
+// AES-GCM chunks, all sealed under the same data key. Every chunk but the last
+// holds exactly ChunkSize bytes of plaintext. The nonce of each chunk is the
+// random prefix stored in the preamble, followed by the chunk counter and a
+// flag marking the final chunk, so chunks cannot be reordered, dropped or
+// truncated without failing authentication.
+const (
+	defaultChunkSize  = 64 * 1024
+	maxChunkSize      = 16 * 1024 * 1024
+	streamNoncePrefix = 7
+)
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type chunkWriter struct {
+	w         io.Writer
+	gcm       cipher.AEAD
+	aad       []byte
+	prefix    []byte
+	counter   uint32
+	chunkSize int
+	buf       []byte
+	closed    bool
+	err       error
+}
+
+// newChunkWriter completes env with a random nonce prefix, writes its
+// preamble to w and returns a writer encrypting everything written to it;
+// Close seals the final chunk but does not close w
+func newChunkWriter(w io.Writer, env *envelope, key []byte) (io.WriteCloser, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if env.header.ChunkSize <= 0 {
+		env.header.ChunkSize = defaultChunkSize
+	}
+
+	env.nonce = make([]byte, streamNoncePrefix)
+	if _, err := rand.Read(env.nonce); err != nil {
+		return nil, err
+	}
+	if err := writeEnvelope(w, env); err != nil {
+		return nil, err
+	}
+
+	return &chunkWriter{
+		w:         w,
+		gcm:       gcm,
+		aad:       env.aad,
+		prefix:    env.nonce,
+		chunkSize: env.header.ChunkSize,
+		buf:       make([]byte, 0, env.header.ChunkSize),
+	}, nil
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.closed {
+		return 0, errors.New("write to closed envelope")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full buffer is only sealed once more data arrives, so the final
+		// chunk is never empty unless the whole payload is
+		if len(c.buf) == c.chunkSize {
+			if c.err = c.seal(false); c.err != nil {
+				return written, c.err
+			}
+		}
+		nn := copy(c.buf[len(c.buf):c.chunkSize], p)
+		c.buf = c.buf[:len(c.buf)+nn]
+		p = p[nn:]
+		written += nn
+	}
+	return written, nil
+}
+
+func (c *chunkWriter) Close() error {
+	if c.closed {
+		return c.err
+	}
+	c.closed = true
+	if c.err != nil {
+		return c.err
+	}
+	c.err = c.seal(true)
+	return c.err
+}
+
+func (c *chunkWriter) seal(last bool) error {
+	sealed := c.gcm.Seal(nil, chunkNonce(c.prefix, c.counter, last), c.buf, c.aad)
+	if err := writeField(c.w, sealed); err != nil {
+		return err
+	}
+	c.buf = c.buf[:0]
+	c.counter++
+	if c.counter == 0 {
+		return errors.New("envelope exceeds maximum number of chunks")
+	}
+	return nil
+}
+
+type chunkReader struct {
+	r         io.Reader
+	gcm       cipher.AEAD
+	aad       []byte
+	prefix    []byte
+	counter   uint32
+	chunkSize int
+	buf       []byte
+	done      bool
+	err       error
+}
+
+func newChunkReader(r io.Reader, gcm cipher.AEAD, env *envelope) (io.Reader, error) {
+	if len(env.nonce) != streamNoncePrefix {
+		return nil, errors.New("invalid envelope nonce")
+	}
+	if env.header.ChunkSize <= 0 || env.header.ChunkSize > maxChunkSize {
+		return nil, errors.New("invalid envelope chunk size")
+	}
+	return &chunkReader{
+		r:         r,
+		gcm:       gcm,
+		aad:       env.aad,
+		prefix:    env.nonce,
+		chunkSize: env.header.ChunkSize,
+	}, nil
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.next()
+	}
+	nn := copy(p, c.buf)
+	c.buf = c.buf[nn:]
+	return nn, nil
+}
+
+// next reads and authenticates the following chunk
+func (c *chunkReader) next() error {
+	sealed, err := readField(c.r, c.chunkSize+c.gcm.Overhead())
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := c.gcm.Open(nil, chunkNonce(c.prefix, c.counter, false), sealed, c.aad)
+	if err == nil && len(plaintext) != c.chunkSize {
+		return errors.New("envelope chunk has unexpected size")
+	}
+	if err != nil {
+		plaintext, err = c.gcm.Open(nil, chunkNonce(c.prefix, c.counter, true), sealed, c.aad)
+		if err != nil {
+			return errors.New("envelope authentication failed")
+		}
+		c.done = true
+		var trailing [1]byte
+		if nn, _ := io.ReadFull(c.r, trailing[:]); nn != 0 {
+			return errors.New("trailing data after envelope")
+		}
+	}
+
+	c.counter++
+	if c.counter == 0 {
+		return errors.New("envelope exceeds maximum number of chunks")
+	}
+	c.buf = plaintext
+	return nil
+}
@@ -0,0 +1,188 @@
+package bundle
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// X25519 recipients follow the design of age: the data key is wrapped under
+// a key derived from an ephemeral X25519 exchange with the recipient's
+// public key, so bundles can be created without access to the private key.
+const (
+	x25519PublicPrefix = "x25519:"
+	x25519SecretPrefix = "X25519-SECRET-KEY:"
+	x25519Info         = "vault-dump/x25519"
+)
+
+var wrapNonce = make([]byte, chacha20poly1305.NonceSize)
+
+type x25519Provider struct {
+	public  []byte
+	private []byte
+}
+
+// NewX25519Recipient returns a provider wrapping data keys for the public key
+// encoded in recipient, as printed by GenerateX25519Identity
+func NewX25519Recipient(recipient string) (Provider, error) {
+	public, err := decodeX25519(recipient, x25519PublicPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &x25519Provider{public: public}, nil
+}
+
+// NewX25519Identity returns a provider able to unwrap data keys wrapped for
+// the public key matching the secret key encoded in identity
+func NewX25519Identity(identity string) (Provider, error) {
+	private, err := decodeX25519(identity, x25519SecretPrefix)
+	if err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &x25519Provider{public: public, private: private}, nil
+}
+
+// ReadX25519Identities parses an identity file, one secret key per line with
+// blank lines and lines starting with # ignored
+func ReadX25519Identities(r io.Reader) ([]Provider, error) {
+	identities := make([]Provider, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := NewX25519Identity(line)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no identities found")
+	}
+	return identities, nil
+}
+
+// GenerateX25519Identity returns a new encoded secret key and its public key
+func GenerateX25519Identity() (string, string, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return "", "", err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return x25519SecretPrefix + base64.RawURLEncoding.EncodeToString(private),
+		x25519PublicPrefix + base64.RawURLEncoding.EncodeToString(public), nil
+}
+
+func decodeX25519(s string, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("X25519 key must begin with %s", prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(key) != curve25519.PointSize {
+		return nil, errors.New("invalid X25519 key")
+	}
+	return key, nil
+}
+
+func (x *x25519Provider) Name() string {
+	return X25519
+}
+
+func (x *x25519Provider) recipient() string {
+	return x25519PublicPrefix + base64.RawURLEncoding.EncodeToString(x.public)
+}
+
+func (x *x25519Provider) Wrap(key []byte) (*Stanza, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, x.public)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := sealKey(x25519KEK(shared, share, x.public), key)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{
+		Provider: X25519,
+		KeyID:    x.recipient(),
+		Params:   map[string]string{"epk": base64.RawURLEncoding.EncodeToString(share)},
+		Wrapped:  wrapped,
+	}, nil
+}
+
+func (x *x25519Provider) Unwrap(stanza *Stanza) ([]byte, error) {
+	if x.private == nil {
+		return nil, errors.New("no X25519 identity configured")
+	}
+	if stanza.KeyID != x.recipient() {
+		return nil, errors.New("bundle was not encrypted for this identity")
+	}
+	share, err := base64.RawURLEncoding.DecodeString(stanza.Params["epk"])
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	shared, err := curve25519.X25519(x.private, share)
+	if err != nil {
+		return nil, err
+	}
+	return openKey(x25519KEK(shared, share, x.public), stanza.Wrapped)
+}
+
+func x25519KEK(shared, share, public []byte) []byte {
+	salt := append(append([]byte{}, share...), public...)
+	kek := make([]byte, chacha20poly1305.KeySize)
+	io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519Info)), kek)
+	return kek
+}
+
+// sealKey wraps a data key under a key encryption key that is only ever used
+// once, so a fixed nonce is safe
+func sealKey(kek, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, wrapNonce, key, nil), nil
+}
+
+func openKey(kek, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(kek)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, wrapNonce, wrapped, nil)
+	if err != nil {
+		return nil, errors.New("failed to unwrap data key")
+	}
+	return key, nil
+}
@@ -0,0 +1,69 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	None = ""
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Algorithms lists the accepted values of Validate, NewWriter and NewReader
+var Algorithms = []string{Gzip, Zstd}
+
+// Validate returns an error unless alg is empty or a supported algorithm
+func Validate(alg string) error {
+	if alg == None {
+		return nil
+	}
+	for _, a := range Algorithms {
+		if alg == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported compression %q, we only accept: %v", alg, Algorithms)
+}
+
+// NewWriter returns a writer compressing into w with alg, closing it flushes
+// the compressed stream but does not close w
+func NewWriter(w io.Writer, alg string) (io.WriteCloser, error) {
+	switch alg {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, Validate(alg)
+}
+
+// NewReader returns a reader decompressing r with alg
+func NewReader(r io.Reader, alg string) (io.ReadCloser, error) {
+	switch alg {
+	case None:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, Validate(alg)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
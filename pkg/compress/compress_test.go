@@ -0,0 +1,60 @@
+package compress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSuiteCompress(tt *testing.T) {
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Round trip without compression", "RoundTrip", []string{None, "This is a test!"}, "This is a test!", true},
+			{"Round trip gzip", "RoundTrip", []string{Gzip, strings.Repeat("This is a test!", 100)}, strings.Repeat("This is a test!", 100), true},
+			{"Round trip zstd", "RoundTrip", []string{Zstd, strings.Repeat("This is a test!", 100)}, strings.Repeat("This is a test!", 100), true},
+			{"Round trip empty zstd", "RoundTrip", []string{Zstd, ""}, "", true},
+			{"Unknown algorithm", "RoundTrip", []string{"xyzzy", "test"}, "", false},
+			{"Validate gzip", "Validate", []string{Gzip}, "", true},
+			{"Validate unknown", "Validate", []string{"lz4"}, "", false},
+		}
+	)
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "RoundTrip":
+			var buf bytes.Buffer
+			ww, err := NewWriter(&buf, test.inputs[0])
+			success = (err == nil)
+			if success {
+				ww.Write([]byte(test.inputs[1]))
+				ww.Close()
+				rr, err := NewReader(&buf, test.inputs[0])
+				success = (err == nil)
+				if success {
+					out, err := ioutil.ReadAll(rr)
+					success = (err == nil)
+					norm = string(out)
+				}
+			}
+		case "Validate":
+			success = (Validate(test.inputs[0]) == nil)
+		}
+
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
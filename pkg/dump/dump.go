@@ -6,6 +6,7 @@ package dump
 // like it does not if your token is not granted access to see it
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
@@ -21,16 +22,38 @@ type Config struct {
 	Debug       bool
 	InputPath   string
 	Filename    string
+	Fingerprint Fingerprinter
+	Inventory   bool
 	Output      *output
+	Seal        Sealer
 	VaultConfig *vault.Config
+
+	count int
+}
+
+// Sealer encrypts every value of a dump individually, see pkg/sealed
+type Sealer interface {
+	Seal(data map[string]interface{}) (map[string]interface{}, error)
 }
 
 func New(c *Config) (*Config, error) {
+	if c.Output != nil && c.Output.GetEncoding() == "csv" && !c.Inventory {
+		return nil, errors.New("csv encoding is only supported for inventory dumps")
+	}
+	if c.Inventory && c.Fingerprint != nil {
+		return nil, errors.New("inventory and fingerprint dumps are mutually exclusive")
+	}
+	if c.Seal != nil && (c.Inventory || c.Fingerprint != nil) {
+		return nil, errors.New("inventory and fingerprint dumps carry no secret values to encrypt")
+	}
 	return &Config{
 		Debug:       c.Debug,
 		InputPath:   c.InputPath,
 		Filename:    c.Filename,
+		Fingerprint: c.Fingerprint,
+		Inventory:   c.Inventory,
 		Output:      c.Output,
+		Seal:        c.Seal,
 		VaultConfig: c.VaultConfig,
 	}, nil
 }
@@ -41,6 +64,8 @@ func (c *Config) Secrets() error {
 		return err
 	}
 
+	secretScraper.Inventory = c.Inventory
+
 	var wg sync.WaitGroup
 
 	secretScraper.Run(c.InputPath, &wg, runtime.NumCPU())
@@ -51,6 +76,16 @@ func (c *Config) Secrets() error {
 		return nil
 	}
 
+	if c.Fingerprint != nil {
+		for path, data := range secretScraper.Data {
+			fp, err := fingerprintData(c.Fingerprint, data)
+			if err != nil {
+				return fmt.Errorf("failed to fingerprint %s: %w", path, err)
+			}
+			secretScraper.Data[path] = fp
+		}
+	}
+
 	if err := c.ProcessOutput(secretScraper.Data); err != nil {
 		return err
 	}
@@ -67,22 +102,9 @@ func isDir(p string) bool {
 }
 
 func (c *Config) writeToFile(data map[string]interface{}) error {
-	var (
-		output string
-		err    error
-	)
-
-	switch c.Output.GetEncoding() {
-	case "yaml":
-		output, err = print.ToYaml(data)
-		if err != nil {
-			return err
-		}
-	default:
-		output, err = print.ToJSON(data)
-		if err != nil {
-			return err
-		}
+	output, err := c.encode(data)
+	if err != nil {
+		return err
 	}
 
 	filename := fmt.Sprintf("%s/%s.%s", c.Output.GetPath(), c.Filename, c.Output.GetEncoding())
@@ -93,6 +115,28 @@ func (c *Config) writeToFile(data map[string]interface{}) error {
 	return nil
 }
 
+// encode renders data in the configured output encoding
+func (c *Config) encode(data map[string]interface{}) (string, error) {
+	if c.Inventory {
+		return encodeInventory(data, c.Output.GetEncoding())
+	}
+
+	if c.Seal != nil {
+		sealed, err := c.Seal.Seal(data)
+		if err != nil {
+			return "", err
+		}
+		data = sealed
+	}
+
+	switch c.Output.GetEncoding() {
+	case "yaml":
+		return print.ToYaml(data)
+	default:
+		return print.ToJSON(data)
+	}
+}
+
 // GetPathForOutput
 func GetPathForOutput(path string) string {
 	if path == "" {
@@ -107,7 +151,11 @@ func (c *Config) ProcessOutput(m map[string]interface{}) error {
 	switch c.Output.GetKind() {
 
 	case "stdout":
-		print.Stdout(m, c.Output.GetEncoding())
+		output, err := c.encode(m)
+		if err != nil {
+			return err
+		}
+		fmt.Println(output)
 	default:
 		if err := c.writeToFile(m); err != nil {
 			return err
@@ -115,6 +163,12 @@ func (c *Config) ProcessOutput(m map[string]interface{}) error {
 
 	}
 
+	c.count = len(m)
 	log.Printf("Discovered %v secrets\n", len(m))
 	return nil
 }
+
+// Count is the number of secrets written by Secrets
+func (c *Config) Count() int {
+	return c.count
+}
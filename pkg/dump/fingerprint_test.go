@@ -0,0 +1,45 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/print"
+)
+
+func TestSuiteFingerprint(tt *testing.T) {
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      map[string]interface{}
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Empty key", "", map[string]interface{}{"foo": "bar"}, "", false},
+			{"String value", "key", map[string]interface{}{"foo": "bar"}, `{"foo":"hmac-sha256:e893fc477815f5db254ee64f8066a2cc0c4f2aa79629513f424bb0a5aa8a2021"}`, true},
+			{"Nested value", "key", map[string]interface{}{"db": map[string]interface{}{"port": 5432}}, `{"db":{"port":"hmac-sha256:462697851e1614c08e2bdf1d0334ac2150e4bfefe846337f3288512484845c85"}}`, true},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		ff, err := NewHMACFingerprinter([]byte(test.action))
+		success = (err == nil)
+		if success {
+			var out interface{}
+			out, err = fingerprintData(ff, test.inputs)
+			success = (err == nil)
+			norm, _ = print.ToJSON(out)
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/print"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// InventoryEntry describes a secret without carrying any of its values
+type InventoryEntry struct {
+	Path           string         `json:"path" yaml:"path"`
+	Keys           []string       `json:"keys" yaml:"keys"`
+	Lengths        map[string]int `json:"lengths" yaml:"lengths"`
+	KVVersion      int            `json:"kv_version" yaml:"kv_version"`
+	CreatedTime    string         `json:"created_time,omitempty" yaml:"created_time,omitempty"`
+	UpdatedTime    string         `json:"updated_time,omitempty" yaml:"updated_time,omitempty"`
+	CurrentVersion int            `json:"current_version,omitempty" yaml:"current_version,omitempty"`
+}
+
+var inventoryCSVHeader = []string{"path", "key", "length", "kv_version", "created_time", "updated_time", "current_version"}
+
+// newInventoryEntry builds an entry from a secret read at path, metadata is
+// the response from the KV v2 metadata endpoint and may be nil
+func newInventoryEntry(path string, vs *vaultapi.Secret, metadata *vaultapi.Secret) *InventoryEntry {
+	entry := &InventoryEntry{
+		Path:      path,
+		Keys:      []string{},
+		Lengths:   make(map[string]int),
+		KVVersion: 1,
+	}
+
+	data := vs.Data
+	if inner, ok := vs.Data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := vs.Data["metadata"]; hasMetadata {
+			entry.KVVersion = 2
+			data = inner
+		}
+	}
+
+	for k, v := range data {
+		entry.Keys = append(entry.Keys, k)
+		entry.Lengths[k] = valueLength(v)
+	}
+	sort.Strings(entry.Keys)
+
+	if metadata != nil && metadata.Data != nil {
+		entry.CreatedTime = toString(metadata.Data["created_time"])
+		entry.UpdatedTime = toString(metadata.Data["updated_time"])
+		entry.CurrentVersion = toInt(metadata.Data["current_version"])
+	} else if vm, ok := vs.Data["metadata"].(map[string]interface{}); ok && entry.KVVersion == 2 {
+		// fall back to the version metadata returned alongside the data
+		entry.CreatedTime = toString(vm["created_time"])
+		entry.CurrentVersion = toInt(vm["version"])
+	}
+
+	return entry
+}
+
+// kvMetadataPath maps a KV v2 data path to its metadata path
+func kvMetadataPath(path string) string {
+	return strings.Replace(path, "/data/", "/metadata/", 1)
+}
+
+func valueLength(v interface{}) int {
+	switch vv := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(vv)
+	default:
+		b, err := json.Marshal(vv)
+		if err != nil {
+			return 0
+		}
+		return len(b)
+	}
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func toInt(v interface{}) int {
+	switch vv := v.(type) {
+	case json.Number:
+		n, _ := vv.Int64()
+		return int(n)
+	case float64:
+		return int(vv)
+	case int:
+		return vv
+	}
+	return 0
+}
+
+// sortedInventory returns the inventory entries of m ordered by path
+func sortedInventory(m map[string]interface{}) []*InventoryEntry {
+	entries := make([]*InventoryEntry, 0, len(m))
+	for _, v := range m {
+		if entry, ok := v.(*InventoryEntry); ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// encodeInventory renders the inventory in the given encoding, CSV output
+// has one row per key
+func encodeInventory(m map[string]interface{}, encoding string) (string, error) {
+	entries := sortedInventory(m)
+
+	switch encoding {
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(inventoryCSVHeader); err != nil {
+			return "", err
+		}
+		for _, e := range entries {
+			keys := e.Keys
+			if len(keys) == 0 {
+				keys = []string{""}
+			}
+			for _, k := range keys {
+				row := []string{
+					e.Path,
+					k,
+					strconv.Itoa(e.Lengths[k]),
+					strconv.Itoa(e.KVVersion),
+					e.CreatedTime,
+					e.UpdatedTime,
+					strconv.Itoa(e.CurrentVersion),
+				}
+				if err := w.Write(row); err != nil {
+					return "", err
+				}
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case "yaml":
+		return print.ToYaml(entries)
+	default:
+		b, err := json.Marshal(entries)
+		if err != nil {
+			return "", fmt.Errorf("error when marshalling inventory: %w", err)
+		}
+		return string(b), nil
+	}
+}
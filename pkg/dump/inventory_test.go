@@ -0,0 +1,58 @@
+package dump
+
+import (
+	"encoding/json"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+func TestSuiteInventory(tt *testing.T) {
+
+	v1 := &vaultapi.Secret{Data: map[string]interface{}{"user": "admin", "pass": "hunter2"}}
+	v2 := &vaultapi.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"token": "abcdef"},
+		"metadata": map[string]interface{}{"created_time": "2021-01-02T00:00:00Z", "version": json.Number("3")},
+	}}
+	v2meta := &vaultapi.Secret{Data: map[string]interface{}{
+		"created_time":    "2021-01-01T00:00:00Z",
+		"updated_time":    "2021-01-02T00:00:00Z",
+		"current_version": json.Number("3"),
+	}}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      map[string]interface{}
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Empty CSV", "csv", map[string]interface{}{}, "path,key,length,kv_version,created_time,updated_time,current_version\n", true},
+			{"KV v1 CSV", "csv", map[string]interface{}{
+				"/secret/foo": newInventoryEntry("/secret/foo", v1, nil),
+			}, "path,key,length,kv_version,created_time,updated_time,current_version\n/secret/foo,pass,7,1,,,0\n/secret/foo,user,5,1,,,0\n", true},
+			{"KV v2 JSON", "json", map[string]interface{}{
+				"/kv/data/bar": newInventoryEntry("/kv/data/bar", v2, v2meta),
+			}, `[{"path":"/kv/data/bar","keys":["token"],"lengths":{"token":6},"kv_version":2,"created_time":"2021-01-01T00:00:00Z","updated_time":"2021-01-02T00:00:00Z","current_version":3}]`, true},
+			{"KV v2 JSON without metadata", "json", map[string]interface{}{
+				"/kv/data/bar": newInventoryEntry("/kv/data/bar", v2, nil),
+			}, `[{"path":"/kv/data/bar","keys":["token"],"lengths":{"token":6},"kv_version":2,"created_time":"2021-01-02T00:00:00Z","current_version":3}]`, true},
+		}
+	)
+
+	for _, test := range tests {
+		output, err := encodeInventory(test.inputs, test.action)
+		success = (err == nil)
+		norm = output
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package dump
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NameData holds the tokens available to --dest and --filename templates
+type NameData struct {
+	// Host is the host name of the Vault server, without the port
+	Host string
+	// Hostname is the name of the machine running the dump
+	Hostname string
+	// PathSlug is the input paths made safe for file names, joined by "_"
+	PathSlug  string
+	Date      string
+	Time      string
+	Timestamp string
+	Year      string
+	Month     string
+	Day       string
+}
+
+var slugUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// NewNameData returns the template tokens for a dump of paths, a comma
+// separated list, from the vault at vaultAddr started at now
+func NewNameData(vaultAddr string, paths string, now time.Time) *NameData {
+	now = now.UTC()
+	data := &NameData{
+		Host:      vaultAddr,
+		Date:      now.Format("2006-01-02"),
+		Time:      now.Format("150405"),
+		Timestamp: now.Format("20060102T150405Z"),
+		Year:      now.Format("2006"),
+		Month:     now.Format("01"),
+		Day:       now.Format("02"),
+	}
+	if uu, err := url.Parse(vaultAddr); err == nil && uu.Hostname() != "" {
+		data.Host = uu.Hostname()
+	}
+	data.Hostname, _ = os.Hostname()
+
+	slugs := []string{}
+	for _, path := range strings.Split(paths, ",") {
+		if slug := strings.Trim(slugUnsafe.ReplaceAllString(path, "-"), "-."); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+	data.PathSlug = strings.Join(slugs, "_")
+	return data
+}
+
+// IsTemplate reports whether name contains template actions
+func IsTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// ExpandName fills in the tokens of the template name, unknown tokens are an
+// error
+func ExpandName(name string, data *NameData) (string, error) {
+	if !IsTemplate(name) {
+		return name, nil
+	}
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template %q: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid name template %q: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// StaticPrefix returns the directory of name above its first template
+// action, or name itself when it is not a template
+func StaticPrefix(name string) string {
+	idx := strings.Index(name, "{{")
+	if idx < 0 {
+		return name
+	}
+	return strings.TrimSuffix(name[:strings.LastIndex(name[:idx], "/")+1], "/")
+}
@@ -0,0 +1,56 @@
+package dump
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSuiteName(tt *testing.T) {
+
+	now := time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC)
+	data := NewNameData("https://vault.prod.example.com:8200", "/secret/app one/,kv/", now)
+	hostname, _ := os.Hostname()
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Plain name", "Expand", "s3://bk/prod", "s3://bk/prod", true},
+			{"Host and date", "Expand", "s3://bk/{{.Host}}/{{.Date}}", "s3://bk/vault.prod.example.com/2024-03-05", true},
+			{"Path slug", "Expand", "{{.PathSlug}}", "secret-app-one_kv", true},
+			{"Timestamp", "Expand", "dump-{{.Timestamp}}", "dump-20240305T070809Z", true},
+			{"Date parts", "Expand", "{{.Year}}/{{.Month}}/{{.Day}}/{{.Time}}", "2024/03/05/070809", true},
+			{"Hostname", "Expand", "{{.Hostname}}", hostname, true},
+			{"Unknown token", "Expand", "{{.Nope}}", "", false},
+			{"Bad template", "Expand", "{{.Host", "", false},
+			{"Prefix of plain name", "Prefix", "s3://bk/prod", "s3://bk/prod", true},
+			{"Prefix of template", "Prefix", "s3://bk/prod/{{.Host}}/{{.Date}}", "s3://bk/prod", true},
+			{"Prefix of partial segment", "Prefix", "s3://bk/prod-{{.Date}}", "s3://bk", true},
+		}
+	)
+
+	for _, test := range tests {
+		var err error
+		switch test.action {
+		case "Expand":
+			norm, err = ExpandName(test.inputs, data)
+		case "Prefix":
+			norm = StaticPrefix(test.inputs)
+		}
+		success = (err == nil)
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
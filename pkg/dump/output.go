@@ -33,7 +33,7 @@ func (o *output) setPath(s string) bool {
 	return true
 }
 func (o *output) setEncoding(s string) bool {
-	expectedEncodings := []string{"json", "yaml"}
+	expectedEncodings := []string{"json", "yaml", "csv"}
 	for _, e := range expectedEncodings {
 		if s == e {
 			o.encoding = s
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/dathan/go-vault-dump/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
 )
 
 const (
@@ -31,6 +32,7 @@ type SecretScraper struct {
 	find        *secretPathStream
 	secrets     *secretStream
 	Data        map[string]interface{}
+	Inventory   bool // collect InventoryEntry values instead of secret data
 	VaultConfig *vault.Config
 }
 
@@ -157,6 +159,10 @@ func (s *SecretScraper) secretProducer(ctx context.Context, cancelFunc context.C
 					}
 				}
 
+				if data != nil && s.Inventory {
+					data = s.inventoryEntry(path, vaultSecret)
+				}
+
 				if data != nil {
 					secret := secret{
 						path: path,
@@ -171,3 +177,17 @@ func (s *SecretScraper) secretProducer(ctx context.Context, cancelFunc context.C
 		}
 	}
 }
+
+// inventoryEntry describes the secret read at path, looking up the KV v2
+// metadata endpoint for creation and update times when available
+func (s *SecretScraper) inventoryEntry(path string, vs *vaultapi.Secret) *InventoryEntry {
+	var metadata *vaultapi.Secret
+	if _, ok := vs.Data["metadata"]; ok {
+		var err error
+		metadata, err = s.VaultConfig.Client.Logical().Read(kvMetadataPath(path))
+		if err != nil {
+			log.Printf("failed to get metadata for %s, %s\n", path, err.Error())
+		}
+	}
+	return newInventoryEntry(path, vs, metadata)
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,8 +26,15 @@ var DatabaseConnectionDetailsKey = "connection_details"
 // Config
 type Config struct {
 	VaultConfig *vault.Config
-	wg          *sync.WaitGroup
-	errInfo     *errInfo
+	// Paths limits the load to secrets at or below these paths, every
+	// secret is loaded when empty
+	Paths []string
+	// SaveFailed writes the secrets that failed to load, values included, to
+	// a json file in the working directory so they can be retried. Otherwise
+	// only their paths are logged.
+	SaveFailed bool
+	wg         *sync.WaitGroup
+	errInfo    *errInfo
 }
 
 type errInfo struct {
@@ -37,6 +46,8 @@ type errInfo struct {
 func New(c *Config) (*Config, error) {
 	return &Config{
 		VaultConfig: c.VaultConfig,
+		Paths:       c.Paths,
+		SaveFailed:  c.SaveFailed,
 		wg:          new(sync.WaitGroup),
 		errInfo: &errInfo{
 			count: new(syncmap.Map),
@@ -45,18 +56,33 @@ func New(c *Config) (*Config, error) {
 	}, nil
 }
 
-// FromFile
+// FromFile loads the secrets of the json dump at filepath
 func (c *Config) FromFile(filepath string) error {
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	signalChan := make(chan os.Signal, 1)
-	go signalHandler(ctx, cancelFunc, signalChan)
+	ff, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer ff.Close()
+	return c.FromReader(ff)
+}
 
-	secrets, err := readSecretsFromFile(filepath)
+// FromReader loads the secrets of the json dump read from r, so a dump
+// decrypted in memory or while streaming is never written to disk
+func (c *Config) FromReader(r io.Reader) error {
+	secrets, err := ReadSecrets(r)
 	if err != nil {
-		cancelFunc()
 		return err
 	}
+	return c.FromSecrets(secrets)
+}
+
+// FromSecrets loads secrets, a map of vault paths to their data as decoded
+// from a json dump. The secret data may be modified while loading.
+func (c *Config) FromSecrets(secrets map[string]interface{}) error {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	signalChan := make(chan os.Signal, 1)
+	go signalHandler(ctx, cancelFunc, signalChan)
 
 	secretChan := make(chan map[string]interface{})
 	c.wg.Add(1)
@@ -73,7 +99,10 @@ func (c *Config) FromFile(filepath string) error {
 		log.Println(k, v.(int))
 		return true
 	})
-	if err := writeFailedToFile(c.errInfo.data); err != nil {
+	if !c.SaveFailed {
+		logFailed(c.errInfo.data)
+	} else if err := writeFailedToFile(c.errInfo.data); err != nil {
+		cancelFunc()
 		return err
 	}
 
@@ -81,6 +110,37 @@ func (c *Config) FromFile(filepath string) error {
 	return nil
 }
 
+// Failed returns the number of secrets that could not be written
+func (c *Config) Failed() int {
+	return len(c.FailedPaths())
+}
+
+// FailedPaths returns the sorted paths of the secrets that could not be
+// written
+func (c *Config) FailedPaths() []string {
+	return failedPaths(c.errInfo.data)
+}
+
+func failedPaths(sm *sync.Map) []string {
+	failed := []string{}
+	sm.Range(func(k, v interface{}) bool {
+		failed = append(failed, k.(string))
+		return true
+	})
+	sort.Strings(failed)
+	return failed
+}
+
+// logFailed logs the paths of the secrets that failed to load, without their
+// values
+func logFailed(sm *sync.Map) {
+	failed := failedPaths(sm)
+	if len(failed) == 0 {
+		return
+	}
+	log.Printf("Failed to load %d secrets: %s", len(failed), strings.Join(failed, ", "))
+}
+
 func writeFailedToFile(sm *sync.Map) error {
 	failed := make(map[string]interface{})
 	sm.Range(func(k, v interface{}) bool {
@@ -110,9 +170,9 @@ func writeFailedToFile(sm *sync.Map) error {
 	return nil
 }
 
-// readSecretsFromFile returns a map from the given json file
-func readSecretsFromFile(filepath string) (map[string]interface{}, error) {
-	data, err := ioutil.ReadFile(filepath)
+// ReadSecrets returns the secrets of the json dump read from r
+func ReadSecrets(r io.Reader) (map[string]interface{}, error) {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
 		return map[string]interface{}{}, err
 	}
@@ -149,7 +209,7 @@ func (c *Config) secretProducer(ctx context.Context, secrets map[string]interfac
 			close(secretChan)
 			return
 		default:
-			ignored := false
+			ignored := !c.selected(p)
 			for _, ip := range c.VaultConfig.Ignore.Paths {
 				if strings.HasPrefix(p, ip) {
 					ignored = true
@@ -177,6 +237,21 @@ func (c *Config) secretProducer(ctx context.Context, secrets map[string]interfac
 	log.Println("Completed map to channel")
 }
 
+// selected reports whether the secret at p is at or below one of c.Paths
+func (c *Config) selected(p string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	p = vault.EnsureNoLeadingSlash(vault.EnsureNoTrailingSlash(p))
+	for _, sp := range c.Paths {
+		sp = vault.EnsureNoLeadingSlash(vault.EnsureNoTrailingSlash(sp))
+		if sp == "" || p == sp || strings.HasPrefix(p, sp+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) secretConsumer(ctx context.Context, secretChan chan map[string]interface{}) {
 	defer c.wg.Done()
 	for s := range secretChan {
@@ -0,0 +1,271 @@
+// Package lock keeps concurrent runs from writing to the same backup prefix.
+// The lock is an object stored next to the backups, created and renewed with
+// conditional writes so only one run can hold it, and given an expiry time
+// so a run that dies without releasing it does not block the next one for
+// long. The lock is advisory: only runs that take it are kept out.
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/storage"
+)
+
+const (
+	// Name is the name of the lock object within a prefix
+	Name = ".vault-dump.lock"
+
+	// DefaultTTL is how long a lock lasts without a heartbeat
+	DefaultTTL = 5 * time.Minute
+
+	// MinTTL is the shortest TTL accepted, the lock must outlast the
+	// writes that renew it
+	MinTTL = 100 * time.Millisecond
+
+	defaultRetryInterval = 5 * time.Second
+)
+
+// ErrHeld is returned, wrapped with the details of the holder, when the lock
+// is still held once the timeout has passed
+var ErrHeld = errors.New("lock is held")
+
+// Info is the content of a lock object
+type Info struct {
+	Owner      string    `json:"owner"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+	Command    string    `json:"command"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// String describes the holder of a lock
+func (i *Info) String() string {
+	return fmt.Sprintf("%s (%s on %s, pid %d) since %s, expires %s", i.Owner, i.Command, i.Host, i.PID,
+		i.AcquiredAt.UTC().Format(time.RFC3339), i.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// Options configure Acquire
+type Options struct {
+	// Command names what holds the lock, such as "dump"
+	Command string
+	// TTL is how long the lock lasts without being renewed, it is renewed
+	// every third of it. Zero means DefaultTTL, others must be at least
+	// MinTTL.
+	TTL time.Duration
+	// Timeout is how long to wait for a lock held by another run, zero
+	// fails at once
+	Timeout time.Duration
+	// RetryInterval is how often a held lock is checked while waiting
+	RetryInterval time.Duration
+}
+
+// Lock is a held lock, renewed in the background until Release
+type Lock struct {
+	location string
+	ttl      time.Duration
+
+	mu   sync.Mutex
+	info Info
+	etag string
+	lost error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Acquire takes the lock object at location, waiting up to opts.Timeout for
+// another run to release it. An expired lock is taken over.
+func Acquire(location string, opts *Options) (*Lock, error) {
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < MinTTL {
+		return nil, fmt.Errorf("lock TTL %s is shorter than %s", ttl, MinTTL)
+	}
+	retry := opts.RetryInterval
+	if retry <= 0 {
+		retry = defaultRetryInterval
+	}
+	host, _ := os.Hostname()
+	owner, err := newOwner(host)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lock{location: location, ttl: ttl}
+	deadline := time.Now().Add(opts.Timeout)
+	waitingFor := ""
+	for {
+		now := time.Now()
+		l.info = Info{
+			Owner:      owner,
+			Host:       host,
+			PID:        os.Getpid(),
+			Command:    opts.Command,
+			AcquiredAt: now,
+			ExpiresAt:  now.Add(ttl),
+		}
+		data, err := json.Marshal(&l.info)
+		if err != nil {
+			return nil, err
+		}
+
+		l.etag, err = storage.PutIf(location, data, "")
+		if err == nil {
+			l.start()
+			return l, nil
+		}
+		if !errors.Is(err, storage.ErrPreconditionFailed) {
+			return nil, err
+		}
+
+		held, heldTag, err := storage.GetETag(location)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // released in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		holder := &Info{}
+		if err := json.Unmarshal(held, holder); err != nil {
+			return nil, fmt.Errorf("%s: invalid lock object, remove it with force-unlock: %w", location, err)
+		}
+		if now.After(holder.ExpiresAt) {
+			l.etag, err = storage.PutIf(location, data, heldTag)
+			if err == nil {
+				log.Printf("Took over the expired lock %s of %s", location, holder)
+				l.start()
+				return l, nil
+			}
+			if !errors.Is(err, storage.ErrPreconditionFailed) {
+				return nil, err
+			}
+			continue // someone else took it over first
+		}
+
+		if !now.Before(deadline) {
+			return nil, fmt.Errorf("%s: %w by %s", location, ErrHeld, holder)
+		}
+		wait := retry
+		if remaining := deadline.Sub(now); remaining < wait {
+			wait = remaining
+		}
+		if waitingFor != holder.Owner {
+			log.Printf("Waiting for the lock %s held by %s", location, holder)
+			waitingFor = holder.Owner
+		}
+		time.Sleep(wait)
+	}
+}
+
+// start renews the lock every third of its TTL until Release
+func (l *Lock) start() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := l.renew(); err != nil {
+					if l.Lost() != nil {
+						log.Printf("Lost the lock %s: %s", l.location, err)
+						return
+					}
+					log.Printf("Failed to renew the lock %s, retrying: %s", l.location, err)
+				}
+			}
+		}
+	}()
+}
+
+// renew pushes the expiry of the lock back by its TTL, as long as it has not
+// been taken over
+func (l *Lock) renew() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info := l.info
+	info.ExpiresAt = time.Now().Add(l.ttl)
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	etag, err := storage.PutIf(l.location, data, l.etag)
+	if errors.Is(err, storage.ErrPreconditionFailed) {
+		l.lost = fmt.Errorf("error: the lock %s was taken over or removed while held", l.location)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	l.info, l.etag = info, etag
+	return nil
+}
+
+// Lost returns an error if the lock was taken over or removed while held
+func (l *Lock) Lost() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Release stops renewing the lock and removes it, unless it was lost, in
+// which case the error says so: the work done under it may have overlapped
+// with another run.
+func (l *Lock) Release() error {
+	close(l.stop)
+	<-l.done
+	if err := l.Lost(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, etag, err := storage.GetETag(l.location)
+	if err != nil {
+		return fmt.Errorf("error: lock %s: %w", l.location, err)
+	}
+	if etag != l.etag {
+		return fmt.Errorf("error: the lock %s was taken over or removed while held", l.location)
+	}
+	if err := storage.Delete(l.location); err != nil {
+		return fmt.Errorf("error releasing lock %s: %w", l.location, err)
+	}
+	return nil
+}
+
+// Read returns the holder of the lock at location
+func Read(location string) (*Info, error) {
+	data, err := storage.ReadAll(location)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("%s: invalid lock object: %w", location, err)
+	}
+	return info, nil
+}
+
+// newOwner returns an ID unique to this run
+func newOwner(host string) (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(buf)), nil
+}
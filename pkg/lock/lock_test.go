@@ -0,0 +1,112 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/storage"
+)
+
+func TestSuiteLock(tt *testing.T) {
+
+	dir, err := ioutil.TempDir("", "lock-*")
+	if err != nil {
+		tt.Fatalf("FAIL temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	quick := &Options{Command: "test", TTL: 150 * time.Millisecond, RetryInterval: 10 * time.Millisecond}
+	waiting := &Options{Command: "test", TTL: 150 * time.Millisecond, Timeout: time.Second, RetryInterval: 10 * time.Millisecond}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Acquire free lock", "Free", "released", true},
+			{"Acquire held lock", "Held", "", false},
+			{"Wait for held lock", "Wait", "released", true},
+			{"Take over expired lock", "Expired", "released", true},
+			{"Heartbeat keeps lock", "Heartbeat", "", false},
+			{"Release lost lock", "Lost", "", false},
+			{"Acquire invalid lock", "Invalid", "", false},
+			{"Acquire with too short TTL", "Short", "", false},
+		}
+	)
+
+	for ii, test := range tests {
+		norm = ""
+		location := filepath.Join(dir, test.action, Name)
+		os.MkdirAll(filepath.Dir(location), 0755)
+
+		switch test.action {
+		case "Held", "Wait", "Heartbeat", "Lost":
+			holder, err := Acquire(location, quick)
+			if err != nil {
+				tt.Fatalf("FAIL %s: %s", test.description, err)
+			}
+			switch test.action {
+			case "Held", "Heartbeat":
+				defer holder.Release()
+				if test.action == "Heartbeat" {
+					time.Sleep(3 * quick.TTL)
+				}
+			case "Wait":
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					holder.Release()
+				}()
+			case "Lost":
+				storage.Delete(location)
+				time.Sleep(quick.TTL)
+				err = holder.Release()
+			}
+		case "Expired":
+			data, _ := json.Marshal(&Info{Owner: "crashed", ExpiresAt: time.Now().Add(-time.Minute)})
+			ioutil.WriteFile(location, data, 0644)
+		case "Invalid":
+			ioutil.WriteFile(location, []byte("{"), 0644)
+		}
+
+		if test.action == "Lost" {
+			success = (err == nil)
+		} else {
+			opts := quick
+			if test.action == "Wait" {
+				opts = waiting
+			}
+			if test.action == "Short" {
+				opts = &Options{Command: "test", TTL: time.Nanosecond}
+			}
+			var lk *Lock
+			lk, err = Acquire(location, opts)
+			success = (err == nil)
+			if success {
+				err = lk.Release()
+				success = (err == nil)
+				if _, statErr := os.Stat(location); success && os.IsNotExist(statErr) {
+					norm = "released"
+				}
+			} else if test.action == "Held" && !errors.Is(err, ErrHeld) {
+				tt.Errorf("FAIL %s: expected ErrHeld got %v", test.description, err)
+			}
+		}
+
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s (%d): expected %t got %t (%v)", test.description, ii, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
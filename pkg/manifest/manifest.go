@@ -0,0 +1,227 @@
+// Package manifest describes a backup: where and when it was taken, what it
+// covers and the checksums of its plaintext and uploaded object. Manifests
+// are signed so a bundle cannot be swapped or truncated without notice.
+package manifest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	// Ext is appended to the name of a bundle to name its manifest
+	Ext = ".manifest.json"
+
+	// HMACSHA256 is the algorithm recorded for HMAC signatures, KMS
+	// signatures record the KMS signing algorithm
+	HMACSHA256 = "hmac-sha256"
+
+	manifestVersion = 1
+)
+
+// Kinds of dump a manifest describes, only backups and sealed dumps hold
+// secrets that can be restored
+const (
+	KindBackup      = "backup"
+	KindSealed      = "sealed"
+	KindFingerprint = "fingerprint"
+	KindInventory   = "inventory"
+)
+
+// Checksum of a plaintext dump or an uploaded object
+type Checksum struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Signature over the manifest with the signature itself left out
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id,omitempty"`
+	Value     []byte `json:"value"`
+}
+
+// Manifest is stored next to a bundle as <bundle>.manifest.json
+type Manifest struct {
+	Version     int        `json:"version"`
+	ToolVersion string     `json:"tool_version"`
+	VaultAddr   string     `json:"vault_addr"`
+	Paths       []string   `json:"paths"`
+	IgnoreKeys  []string   `json:"ignore_keys"`
+	IgnorePaths []string   `json:"ignore_paths"`
+	SecretCount int        `json:"secret_count"`
+	Kind        string     `json:"kind,omitempty"`
+	Object      string     `json:"object"`
+	Encrypted   bool       `json:"encrypted"`
+	Plaintext   Checksum   `json:"plaintext"`
+	Ciphertext  Checksum   `json:"ciphertext"`
+	StartedAt   string     `json:"started_at"`
+	CompletedAt string     `json:"completed_at"`
+	Signature   *Signature `json:"signature,omitempty"`
+}
+
+// Signer signs the SHA-256 digest of a manifest
+type Signer interface {
+	Algorithm() string
+	KeyID() string
+	Sign(digest []byte) ([]byte, error)
+}
+
+// Verifier checks a signature made by a Signer
+type Verifier interface {
+	Verify(sig *Signature, digest []byte) error
+}
+
+// Resolver returns the verifier for a signature, or an error if none is
+// configured for it
+type Resolver func(sig *Signature) (Verifier, error)
+
+// New returns an unsigned manifest for the current tool version
+func New(toolVersion string) *Manifest {
+	return &Manifest{Version: manifestVersion, ToolVersion: toolVersion}
+}
+
+// Restorable reports whether the dump holds secrets that can be restored.
+// Manifests written before the kind was recorded are not known to.
+func (mm *Manifest) Restorable() bool {
+	return mm.Kind == KindBackup || mm.Kind == KindSealed
+}
+
+// Digest is the SHA-256 of the manifest encoded without its signature
+func (m *Manifest) Digest() ([]byte, error) {
+	mm := *m
+	mm.Signature = nil
+	data, err := json.Marshal(&mm)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// Sign replaces the signature of the manifest
+func (m *Manifest) Sign(signer Signer) error {
+	digest, err := m.Digest()
+	if err != nil {
+		return err
+	}
+	value, err := signer.Sign(digest)
+	if err != nil {
+		return err
+	}
+	m.Signature = &Signature{Algorithm: signer.Algorithm(), KeyID: signer.KeyID(), Value: value}
+	return nil
+}
+
+// Verify checks the signature of the manifest, unsigned manifests fail
+func (m *Manifest) Verify(resolve Resolver) error {
+	if m.Signature == nil {
+		return errors.New("manifest is not signed")
+	}
+	verifier, err := resolve(m.Signature)
+	if err != nil {
+		return err
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return err
+	}
+	if err := verifier.Verify(m.Signature, digest); err != nil {
+		return fmt.Errorf("manifest signature is invalid: %w", err)
+	}
+	return nil
+}
+
+// Encode renders the manifest as indented JSON
+func (m *Manifest) Encode() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Decode parses a manifest written by Encode
+func Decode(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// Hasher computes a Checksum of everything written to it
+type Hasher struct {
+	hh   hash.Hash
+	size int64
+}
+
+func NewHasher() *Hasher {
+	return &Hasher{hh: sha256.New()}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	return h.hh.Write(p)
+}
+
+// Sum returns the checksum of the data written so far
+func (h *Hasher) Sum() Checksum {
+	return Checksum{SHA256: hex.EncodeToString(h.hh.Sum(nil)), Size: h.size}
+}
+
+// TeeReader returns a reader that hashes what it reads from r
+func (h *Hasher) TeeReader(r io.Reader) io.Reader {
+	return io.TeeReader(r, h)
+}
+
+// Check compares a computed checksum with the one recorded, what names the
+// data in the error
+func Check(what string, recorded Checksum, computed Checksum) error {
+	if recorded.SHA256 != computed.SHA256 || recorded.Size != computed.Size {
+		return fmt.Errorf("%s checksum mismatch: manifest has sha256 %s (%d bytes), got %s (%d bytes)",
+			what, recorded.SHA256, recorded.Size, computed.SHA256, computed.Size)
+	}
+	return nil
+}
+
+// HMACKey signs and verifies manifests with a shared secret
+type HMACKey struct {
+	Key []byte
+}
+
+func (k *HMACKey) Algorithm() string {
+	return HMACSHA256
+}
+
+func (k *HMACKey) KeyID() string {
+	return ""
+}
+
+func (k *HMACKey) Sign(digest []byte) ([]byte, error) {
+	if len(k.Key) == 0 {
+		return nil, errors.New("HMAC key must not be empty")
+	}
+	mac := hmac.New(sha256.New, k.Key)
+	mac.Write(digest)
+	return mac.Sum(nil), nil
+}
+
+func (k *HMACKey) Verify(sig *Signature, digest []byte) error {
+	if sig.Algorithm != HMACSHA256 {
+		return fmt.Errorf("unsupported algorithm %q", sig.Algorithm)
+	}
+	expected, err := k.Sign(digest)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, sig.Value) {
+		return errors.New("HMAC mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package manifest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSuiteManifest(tt *testing.T) {
+
+	key := &HMACKey{Key: []byte("manifest key")}
+	resolve := func(sig *Signature) (Verifier, error) {
+		if sig.Algorithm != HMACSHA256 {
+			return nil, errors.New("no verifier")
+		}
+		return key, nil
+	}
+
+	hasher := NewHasher()
+	hasher.Write([]byte("This is a test!"))
+	sum := hasher.Sum()
+
+	signed := func(edit func(*Manifest)) *Manifest {
+		mm := New("test")
+		mm.VaultAddr = "https://127.0.0.1:8200"
+		mm.Paths = []string{"secret/"}
+		mm.SecretCount = 3
+		mm.Kind = KindBackup
+		mm.Plaintext = sum
+		mm.Ciphertext = sum
+		if err := mm.Sign(key); err != nil {
+			tt.Fatalf("FAIL sign: %s", err)
+		}
+		if edit != nil {
+			edit(mm)
+		}
+		// round trip through the stored form
+		data, _ := mm.Encode()
+		out, err := Decode(data)
+		if err != nil {
+			tt.Fatalf("FAIL decode: %s", err)
+		}
+		return out
+	}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      *Manifest
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Verify signed manifest", "Verify", signed(nil), "", true},
+			{"Verify modified secret count", "Verify", signed(func(mm *Manifest) { mm.SecretCount = 2 }), "", false},
+			{"Verify modified checksum", "Verify", signed(func(mm *Manifest) { mm.Ciphertext.SHA256 = strings.Repeat("0", 64) }), "", false},
+			{"Verify signature from another key", "Verify", signed(func(mm *Manifest) { mm.Sign(&HMACKey{Key: []byte("other key")}) }), "", false},
+			{"Verify unknown algorithm", "Verify", signed(func(mm *Manifest) { mm.Signature.Algorithm = "ECDSA_SHA_256" }), "", false},
+			{"Verify unsigned manifest", "Verify", signed(func(mm *Manifest) { mm.Signature = nil }), "", false},
+			{"Verify modified kind", "Verify", signed(func(mm *Manifest) { mm.Kind = KindSealed }), "", false},
+			{"Restorable backup", "Restorable", signed(nil), "", true},
+			{"Restorable sealed dump", "Restorable", signed(func(mm *Manifest) { mm.Kind = KindSealed }), "", true},
+			{"Restorable fingerprint", "Restorable", signed(func(mm *Manifest) { mm.Kind = KindFingerprint }), "", false},
+			{"Restorable inventory", "Restorable", signed(func(mm *Manifest) { mm.Kind = KindInventory }), "", false},
+			{"Restorable without kind", "Restorable", signed(func(mm *Manifest) { mm.Kind = "" }), "", false},
+			{"Check matching checksum", "Check", signed(nil), "", true},
+			{"Check truncated object", "Check", signed(func(mm *Manifest) { mm.Ciphertext.Size-- }), "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "Verify":
+			success = test.inputs.Verify(resolve) == nil
+		case "Restorable":
+			success = test.inputs.Restorable()
+		case "Check":
+			success = Check("ciphertext", test.inputs.Ciphertext, sum) == nil
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
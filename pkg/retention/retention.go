@@ -0,0 +1,101 @@
+// Package retention decides which backups to keep under a
+// grandfather-father-son policy: the newest backup of each of the last N
+// hours, days, weeks and months is kept and the rest can be pruned.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy is the number of hourly, daily, weekly and monthly backups to keep
+type Policy struct {
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Empty reports whether the policy keeps nothing
+func (p Policy) Empty() bool {
+	return p.Hourly <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0
+}
+
+// Backup is one backup considered for pruning
+type Backup struct {
+	Key  string
+	Time time.Time
+	// Successful is set for backups known to have completed, such as those
+	// with a valid manifest
+	Successful bool
+}
+
+// Decision records whether a backup is kept and why
+type Decision struct {
+	Backup
+	Keep    bool
+	Reasons []string
+}
+
+// period buckets backups, all periods are computed in UTC
+type period struct {
+	name  string
+	count int
+	key   func(time.Time) string
+}
+
+// Apply decides which backups to keep, the result is ordered newest first.
+// The newest successful backup, or the newest backup when none is known to
+// be successful, is always kept.
+func Apply(policy Policy, backups []Backup) []Decision {
+	decisions := make([]Decision, len(backups))
+	for ii, bb := range backups {
+		decisions[ii] = Decision{Backup: bb}
+	}
+	sort.SliceStable(decisions, func(ii, jj int) bool {
+		return decisions[ii].Time.After(decisions[jj].Time)
+	})
+
+	periods := []period{
+		{"hourly", policy.Hourly, func(tt time.Time) string { return tt.Format("2006-01-02T15") }},
+		{"daily", policy.Daily, func(tt time.Time) string { return tt.Format("2006-01-02") }},
+		{"weekly", policy.Weekly, func(tt time.Time) string {
+			year, week := tt.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.Monthly, func(tt time.Time) string { return tt.Format("2006-01") }},
+	}
+	for _, pp := range periods {
+		seen := map[string]bool{}
+		for ii := range decisions {
+			if len(seen) >= pp.count {
+				break
+			}
+			key := pp.key(decisions[ii].Time.UTC())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			decisions[ii].keep(pp.name)
+		}
+	}
+
+	if len(decisions) > 0 {
+		newest, reason := 0, "newest"
+		for ii := range decisions {
+			if decisions[ii].Successful {
+				newest, reason = ii, "newest successful"
+				break
+			}
+		}
+		decisions[newest].keep(reason)
+	}
+
+	return decisions
+}
+
+func (d *Decision) keep(reason string) {
+	d.Keep = true
+	d.Reasons = append(d.Reasons, reason)
+}
@@ -0,0 +1,97 @@
+package retention
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupsAt returns backups named by their RFC3339 times, those ending in
+// "!" are successful
+func backupsAt(times ...string) []Backup {
+	backups := []Backup{}
+	for _, tt := range times {
+		ok := strings.HasSuffix(tt, "!")
+		tt = strings.TrimSuffix(tt, "!")
+		parsed, _ := time.Parse(time.RFC3339, tt)
+		backups = append(backups, Backup{Key: tt, Time: parsed, Successful: ok})
+	}
+	return backups
+}
+
+func TestSuiteRetention(tt *testing.T) {
+	var (
+		norm  string
+		tests = []struct {
+			description string
+			policy      Policy
+			inputs      []Backup
+			normOutput  string
+		}{
+			{"Nothing to prune", Policy{Daily: 7}, []Backup{}, ""},
+			{
+				"Keep newest per hour",
+				Policy{Hourly: 2},
+				backupsAt("2024-01-01T10:05:00Z", "2024-01-01T10:45:00Z", "2024-01-01T11:10:00Z", "2024-01-01T09:00:00Z"),
+				"2024-01-01T10:45:00Z,2024-01-01T11:10:00Z",
+			},
+			{
+				"Keep newest per day",
+				Policy{Daily: 2},
+				backupsAt("2024-01-01T01:00:00Z", "2024-01-02T01:00:00Z", "2024-01-02T23:00:00Z", "2024-01-03T05:00:00Z"),
+				"2024-01-02T23:00:00Z,2024-01-03T05:00:00Z",
+			},
+			{
+				"Keep newest per week",
+				Policy{Weekly: 2},
+				backupsAt("2024-01-01T00:00:00Z", "2024-01-07T00:00:00Z", "2024-01-08T00:00:00Z", "2024-01-15T00:00:00Z"),
+				"2024-01-08T00:00:00Z,2024-01-15T00:00:00Z",
+			},
+			{
+				"Keep newest per month",
+				Policy{Monthly: 3},
+				backupsAt("2023-11-30T00:00:00Z", "2023-12-01T00:00:00Z", "2023-12-31T00:00:00Z", "2024-01-15T00:00:00Z", "2024-02-01T00:00:00Z"),
+				"2023-12-31T00:00:00Z,2024-01-15T00:00:00Z,2024-02-01T00:00:00Z",
+			},
+			{
+				"Combine periods",
+				Policy{Daily: 1, Monthly: 2},
+				backupsAt("2023-12-31T00:00:00Z", "2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"),
+				"2023-12-31T00:00:00Z,2024-01-02T00:00:00Z",
+			},
+			{
+				"Guard newest successful",
+				Policy{Daily: 1},
+				backupsAt("2024-01-01T00:00:00Z!", "2024-01-02T00:00:00Z", "2024-01-02T01:00:00Z"),
+				"2024-01-01T00:00:00Z,2024-01-02T01:00:00Z",
+			},
+			{
+				"Guard newest with empty policy",
+				Policy{},
+				backupsAt("2024-01-01T00:00:00Z", "2024-01-02T00:00:00Z"),
+				"2024-01-02T00:00:00Z",
+			},
+		}
+	)
+
+	for _, test := range tests {
+		kept := []string{}
+		decisions := Apply(test.policy, test.inputs)
+		for ii, dd := range decisions {
+			if ii > 0 && dd.Time.After(decisions[ii-1].Time) {
+				tt.Errorf("FAIL %s: decisions are not ordered newest first", test.description)
+			}
+			if dd.Keep {
+				kept = append(kept, dd.Key)
+			}
+		}
+		sort.Strings(kept)
+		norm = strings.Join(kept, ",")
+		if len(decisions) == len(test.inputs) && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Package sealed encrypts the values of a vault dump individually, leaving
+// vault paths and key names readable so sealed dumps can be reviewed and
+// diffed. Values are encrypted with AES-256-GCM under a data key wrapped by
+// bundle providers, and a MAC over the whole document detects added,
+// removed or reordered values.
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// MetadataKey holds the wrapped data key and MAC of a sealed document
+	MetadataKey = "vault_dump"
+
+	// sealedVersion 2 adds the recipients to the MAC, documents of version 1
+	// still open
+	sealedVersion   = 2
+	sealedVersionV1 = 1
+	sealedCipher    = "AES256_GCM"
+	macPrefix       = "hmac-sha256:"
+	macInfo         = "vault-dump/sealed mac"
+)
+
+var valuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|number|bool)\]$`)
+
+// Metadata is stored under MetadataKey
+type Metadata struct {
+	Version      int               `json:"version"`
+	Cipher       string            `json:"cipher"`
+	ToolVersion  string            `json:"tool_version"`
+	LastModified string            `json:"last_modified"`
+	Context      map[string]string `json:"context,omitempty"`
+	Recipients   []*bundle.Stanza  `json:"recipients"`
+	MAC          string            `json:"mac"`
+}
+
+// Options configure NewKey
+type Options struct {
+	Recipients []bundle.Provider
+	// Context is the encryption context the recipients bind the key to
+	Context map[string]string
+}
+
+// Key seals documents under one data key
+type Key struct {
+	key       []byte
+	gcm       cipher.AEAD
+	meta      Metadata
+	recipient *bundle.Stanza
+}
+
+// NewKey generates a data key wrapped for every recipient
+func NewKey(opts *Options) (*Key, error) {
+	key, stanzas, err := bundle.WrapKey(opts.Recipients)
+	if err != nil {
+		return nil, err
+	}
+	return newKey(key, Metadata{
+		Version:    sealedVersion,
+		Cipher:     sealedCipher,
+		Context:    opts.Context,
+		Recipients: stanzas,
+	})
+}
+
+func newKey(key []byte, meta Metadata) (*Key, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// documents opened to be edited are sealed again as the current version
+	meta.Version = sealedVersion
+	meta.MAC = ""
+	return &Key{key: key, gcm: gcm, meta: meta}, nil
+}
+
+// Recipient is the stanza the key was unwrapped from by Open
+func (k *Key) Recipient() *bundle.Stanza {
+	return k.recipient
+}
+
+// IsSealed reports whether doc carries sealed metadata
+func IsSealed(doc map[string]interface{}) bool {
+	_, ok := doc[MetadataKey].(map[string]interface{})
+	return ok
+}
+
+// Seal returns a copy of data with every leaf value encrypted and the
+// metadata added under MetadataKey
+func (k *Key) Seal(data map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := data[MetadataKey]; ok {
+		return nil, fmt.Errorf("document already has a %s key", MetadataKey)
+	}
+
+	sealed, err := k.walk(data, nil, k.sealValue)
+	if err != nil {
+		return nil, err
+	}
+	doc := sealed.(map[string]interface{})
+
+	meta := k.meta
+	meta.ToolVersion = bundle.ToolVersion
+	meta.LastModified = time.Now().UTC().Format(time.RFC3339)
+	meta.MAC, err = k.mac(doc, &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	mm, err := toMap(&meta)
+	if err != nil {
+		return nil, err
+	}
+	doc[MetadataKey] = mm
+	return doc, nil
+}
+
+// Open verifies the MAC of a sealed document and returns its plaintext,
+// along with the key so an edited document can be sealed again
+func Open(doc map[string]interface{}, resolve bundle.Resolver) (map[string]interface{}, *Key, error) {
+	meta, err := readMetadata(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, stanza := range meta.Recipients {
+		stanza.Context = meta.Context
+	}
+	key, stanza, err := bundle.UnwrapKey(meta.Recipients, resolve)
+	if err != nil {
+		return nil, nil, err
+	}
+	kk, err := newKey(key, *meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	kk.recipient = stanza
+
+	body := make(map[string]interface{}, len(doc))
+	for path, vv := range doc {
+		if path != MetadataKey {
+			body[path] = vv
+		}
+	}
+	mac, err := kk.mac(body, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal([]byte(mac), []byte(meta.MAC)) {
+		return nil, nil, errors.New("sealed document MAC mismatch, it has been modified")
+	}
+
+	plaintext, err := kk.walk(body, nil, kk.openValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext.(map[string]interface{}), kk, nil
+}
+
+func readMetadata(doc map[string]interface{}) (*Metadata, error) {
+	raw, ok := doc[MetadataKey]
+	if !ok {
+		return nil, errors.New("document is not sealed")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("invalid sealed metadata: %w", err)
+	}
+	if (meta.Version != sealedVersion && meta.Version != sealedVersionV1) || meta.Cipher != sealedCipher {
+		return nil, fmt.Errorf("unsupported sealed document version %d cipher %q", meta.Version, meta.Cipher)
+	}
+	if len(meta.Recipients) == 0 {
+		return nil, errors.New("sealed document has no recipients")
+	}
+	return meta, nil
+}
+
+// walk rebuilds a document applying fn to every leaf, location is the list
+// of keys and indices leading to it
+func (k *Key) walk(node interface{}, location []string, fn func(interface{}, []string) (interface{}, error)) (interface{}, error) {
+	switch nn := node.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(nn))
+		for kk, vv := range nn {
+			child, err := k.walk(vv, append(location[:len(location):len(location)], kk), fn)
+			if err != nil {
+				return nil, err
+			}
+			out[kk] = child
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(nn))
+		for kk, vv := range nn {
+			ks := fmt.Sprint(kk)
+			child, err := k.walk(vv, append(location[:len(location):len(location)], ks), fn)
+			if err != nil {
+				return nil, err
+			}
+			out[ks] = child
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(nn))
+		for ii, vv := range nn {
+			child, err := k.walk(vv, append(location[:len(location):len(location)], strconv.Itoa(ii)), fn)
+			if err != nil {
+				return nil, err
+			}
+			out[ii] = child
+		}
+		return out, nil
+	}
+	return fn(node, location)
+}
+
+func (k *Key) sealValue(value interface{}, location []string) (interface{}, error) {
+	var plaintext, kind string
+	switch vv := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		plaintext, kind = vv, "str"
+	case bool:
+		plaintext, kind = strconv.FormatBool(vv), "bool"
+	case json.Number:
+		plaintext, kind = vv.String(), "number"
+	case float64, float32, int, int64, int32, uint64, uint32:
+		plaintext, kind = fmt.Sprint(vv), "number"
+	default:
+		return nil, fmt.Errorf("cannot seal %T value at %v", value, location)
+	}
+
+	nonce := make([]byte, k.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := k.gcm.Seal(nil, nonce, []byte(plaintext), locationAAD(location))
+	data, tag := sealed[:len(sealed)-k.gcm.Overhead()], sealed[len(sealed)-k.gcm.Overhead():]
+
+	return fmt.Sprintf("ENC[%s,data:%s,iv:%s,tag:%s,type:%s]", sealedCipher,
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(nonce),
+		base64.StdEncoding.EncodeToString(tag),
+		kind), nil
+}
+
+func (k *Key) openValue(value interface{}, location []string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	ss, ok := value.(string)
+	match := valuePattern.FindStringSubmatch(ss)
+	if !ok || match == nil {
+		return nil, fmt.Errorf("value at %v is not sealed", location)
+	}
+	data, err1 := base64.StdEncoding.DecodeString(match[1])
+	nonce, err2 := base64.StdEncoding.DecodeString(match[2])
+	tag, err3 := base64.StdEncoding.DecodeString(match[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(nonce) != k.gcm.NonceSize() {
+		return nil, fmt.Errorf("value at %v is malformed", location)
+	}
+
+	plaintext, err := k.gcm.Open(nil, nonce, append(data, tag...), locationAAD(location))
+	if err != nil {
+		return nil, fmt.Errorf("value at %v failed authentication", location)
+	}
+
+	switch match[4] {
+	case "bool":
+		return strconv.ParseBool(string(plaintext))
+	case "number":
+		return json.Number(plaintext), nil
+	}
+	return string(plaintext), nil
+}
+
+// locationAAD binds a value to its place in the document so values cannot
+// be moved between keys
+func locationAAD(location []string) []byte {
+	aad, _ := json.Marshal(location)
+	return aad
+}
+
+// mac authenticates every location and sealed value of doc along with the
+// metadata and, from version 2, the recipient stanzas, under a key derived
+// from the data key
+func (k *Key) mac(doc map[string]interface{}, meta *Metadata) (string, error) {
+	macKey := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k.key, nil, []byte(macInfo)), macKey); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, macKey)
+
+	lines := []string{}
+	_, err := k.walk(doc, nil, func(value interface{}, location []string) (interface{}, error) {
+		lines = append(lines, fmt.Sprintf("%s=%v", locationAAD(location), value))
+		return value, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(lines)
+
+	context, err := json.Marshal(meta.Context)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s\n", meta.Version, meta.Cipher, meta.LastModified, context)
+	if meta.Version != sealedVersionV1 {
+		recipients, err := json.Marshal(meta.Recipients)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(mac, "%s\n", recipients)
+	}
+	for _, line := range lines {
+		fmt.Fprintln(mac, line)
+	}
+	return macPrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func toMap(meta *Metadata) (map[string]interface{}, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	mm := map[string]interface{}{}
+	if err := json.Unmarshal(data, &mm); err != nil {
+		return nil, err
+	}
+	return mm, nil
+}
@@ -0,0 +1,131 @@
+package sealed
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+)
+
+// staticProvider stands in for KMS, wrapping is the identity function
+type staticProvider struct{}
+
+func (staticProvider) Name() string { return bundle.KMS }
+func (staticProvider) Wrap(key []byte) (*bundle.Stanza, error) {
+	return &bundle.Stanza{Provider: bundle.KMS, KeyID: "arn:test", Wrapped: key}, nil
+}
+func (staticProvider) Unwrap(stanza *bundle.Stanza) ([]byte, error) { return stanza.Wrapped, nil }
+
+func TestSuiteSealed(tt *testing.T) {
+
+	plaintext := `{"secret/foo/bar":{"baz":"bat","count":3,"empty":null,"enabled":true,"list":["a","b"]},"secret/foo/qux":{"baz":"quux"}}`
+	resolve := func(*bundle.Stanza) (bundle.Provider, error) { return staticProvider{}, nil }
+
+	// seal returns the sealed plaintext document after applying tamper to it
+	seal := func(tamper func(map[string]interface{})) map[string]interface{} {
+		data := map[string]interface{}{}
+		json.Unmarshal([]byte(plaintext), &data)
+		key, err := NewKey(&Options{Recipients: []bundle.Provider{staticProvider{}}})
+		if err != nil {
+			tt.Fatalf("FAIL seal: %s", err)
+		}
+		doc, err := key.Seal(data)
+		if err != nil {
+			tt.Fatalf("FAIL seal: %s", err)
+		}
+		// round trip through JSON as a sealed document would be stored
+		raw, _ := json.Marshal(doc)
+		doc = map[string]interface{}{}
+		json.Unmarshal(raw, &doc)
+		if tamper != nil {
+			tamper(doc)
+		}
+		return doc
+	}
+	secret := func(doc map[string]interface{}, path string) map[string]interface{} {
+		return doc[path].(map[string]interface{})
+	}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      map[string]interface{}
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Open sealed document", "Open", seal(nil), plaintext, true},
+			{"Paths and keys stay readable", "Inspect", seal(nil), "secret/foo/bar,secret/foo/qux,vault_dump", true},
+			{"Open with modified value", "Open", seal(func(doc map[string]interface{}) {
+				secret(doc, "secret/foo/bar")["baz"] = strings.Replace(secret(doc, "secret/foo/bar")["baz"].(string), "data:", "data:A", 1)
+			}), "", false},
+			{"Open with swapped values", "Open", seal(func(doc map[string]interface{}) {
+				bar, qux := secret(doc, "secret/foo/bar"), secret(doc, "secret/foo/qux")
+				bar["baz"], qux["baz"] = qux["baz"], bar["baz"]
+			}), "", false},
+			{"Open with removed path", "Open", seal(func(doc map[string]interface{}) {
+				delete(doc, "secret/foo/qux")
+			}), "", false},
+			{"Open with added key", "Open", seal(func(doc map[string]interface{}) {
+				secret(doc, "secret/foo/qux")["extra"] = secret(doc, "secret/foo/bar")["baz"]
+			}), "", false},
+			{"Open with modified metadata", "Open", seal(func(doc map[string]interface{}) {
+				doc[MetadataKey].(map[string]interface{})["last_modified"] = "2000-01-01T00:00:00Z"
+			}), "", false},
+			{"Open with modified recipient", "Open", seal(func(doc map[string]interface{}) {
+				doc[MetadataKey].(map[string]interface{})["recipients"].([]interface{})[0].(map[string]interface{})["key_id"] = "other"
+			}), "", false},
+			{"Open with added recipient", "Open", seal(func(doc map[string]interface{}) {
+				meta := doc[MetadataKey].(map[string]interface{})
+				meta["recipients"] = append(meta["recipients"].([]interface{}), meta["recipients"].([]interface{})[0])
+			}), "", false},
+			{"Open version 1 document", "Open", seal(func(doc map[string]interface{}) {
+				// version 1 MACs do not cover the recipients
+				meta, _ := readMetadata(doc)
+				delete(doc, MetadataKey)
+				kk, _ := newKey(meta.Recipients[0].Wrapped, *meta)
+				meta.Version = sealedVersionV1
+				meta.MAC, _ = kk.mac(doc, meta)
+				doc[MetadataKey], _ = toMap(meta)
+			}), plaintext, true},
+			{"Open without provider", "OpenNoProvider", seal(nil), "", false},
+			{"Open plaintext document", "Open", map[string]interface{}{"secret/foo/bar": map[string]interface{}{"baz": "bat"}}, "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "Open", "OpenNoProvider":
+			rr := resolve
+			if test.action == "OpenNoProvider" {
+				rr = func(*bundle.Stanza) (bundle.Provider, error) { return nil, errors.New("no provider") }
+			}
+			out, _, err := Open(test.inputs, rr)
+			success = (err == nil)
+			if success {
+				raw, _ := json.Marshal(out)
+				norm = string(raw)
+			}
+		case "Inspect":
+			success = IsSealed(test.inputs)
+			for _, path := range []string{"secret/foo/bar", "secret/foo/qux", MetadataKey} {
+				if _, ok := test.inputs[path]; ok {
+					norm += "," + path
+				}
+			}
+			norm = strings.TrimPrefix(norm, ",")
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8). Each
+// byte of the secret is the constant term of a random polynomial of degree
+// threshold-1, and each share holds the polynomial evaluated at one
+// distinct non-zero x coordinate, stored as the last byte of the share.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// exp and log tables for GF(2^8) with the AES polynomial x^8+x^4+x^3+x+1
+// and generator 3
+var (
+	expTable [255]byte
+	logTable [256]byte
+)
+
+func init() {
+	var xx byte = 1
+	for ii := 0; ii < 255; ii++ {
+		expTable[ii] = xx
+		logTable[xx] = byte(ii)
+		// multiply by the generator 3: xx*2 xor xx
+		hi := xx & 0x80
+		doubled := xx << 1
+		if hi != 0 {
+			doubled ^= 0x1b
+		}
+		xx = doubled ^ xx
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Split divides secret into parts shares, any threshold of which recover it
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+	if threshold < 2 || parts < threshold || parts > 255 {
+		return nil, errors.New("threshold must be at least 2 and no more than the number of shares, at most 255")
+	}
+
+	shares := make([][]byte, parts)
+	for ii := range shares {
+		shares[ii] = make([]byte, len(secret)+1)
+		shares[ii][len(secret)] = byte(ii + 1)
+	}
+
+	coefficients := make([]byte, threshold-1)
+	for bb, sb := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			// Horner's method, highest degree first
+			xx := share[len(secret)]
+			var yy byte
+			for cc := len(coefficients) - 1; cc >= 0; cc-- {
+				yy = mul(yy, xx) ^ coefficients[cc]
+			}
+			share[bb] = mul(yy, xx) ^ sb
+		}
+	}
+	return shares, nil
+}
+
+// Combine recovers the secret from at least threshold shares. Combining
+// fewer shares returns a wrong secret without error, callers must verify it.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("share is too short")
+	}
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if len(share) != size {
+			return nil, errors.New("shares have different lengths")
+		}
+		xx := share[size-1]
+		if xx == 0 || seen[xx] {
+			return nil, errors.New("shares must have distinct non-zero coordinates")
+		}
+		seen[xx] = true
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, size-1)
+	for ii, si := range shares {
+		xi := si[size-1]
+		var basis byte = 1
+		for jj, sj := range shares {
+			if ii == jj {
+				continue
+			}
+			xj := sj[size-1]
+			basis = mul(basis, div(xj, xj^xi))
+		}
+		for bb := range secret {
+			secret[bb] ^= mul(si[bb], basis)
+		}
+	}
+	return secret, nil
+}
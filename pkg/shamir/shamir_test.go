@@ -0,0 +1,62 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSuiteShamir(tt *testing.T) {
+
+	secret := []byte("This is a test!")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		tt.Fatalf("FAIL split: %s", err)
+	}
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      [][]byte
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Combine threshold shares", "Combine", shares[:3], string(secret), true},
+			{"Combine other threshold shares", "Combine", [][]byte{shares[4], shares[1], shares[3]}, string(secret), true},
+			{"Combine all shares", "Combine", shares, string(secret), true},
+			{"Combine below threshold", "CombineWrong", shares[:2], "", true},
+			{"Combine duplicate shares", "Combine", [][]byte{shares[0], shares[0], shares[1]}, "", false},
+			{"Combine single share", "Combine", shares[:1], "", false},
+			{"Combine mismatched shares", "Combine", [][]byte{shares[0], shares[1][1:]}, "", false},
+			{"Split below minimum threshold", "Split", [][]byte{secret, {5, 1}}, "", false},
+			{"Split fewer shares than threshold", "Split", [][]byte{secret, {2, 3}}, "", false},
+			{"Split empty secret", "Split", [][]byte{{}, {5, 3}}, "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "Combine":
+			out, err := Combine(test.inputs)
+			success = (err == nil)
+			norm = string(out)
+		case "CombineWrong":
+			// below the threshold the result carries no information
+			out, err := Combine(test.inputs)
+			success = (err == nil) && !bytes.Equal(out, secret)
+		case "Split":
+			_, err := Split(test.inputs[0], int(test.inputs[1][0]), int(test.inputs[1][1]))
+			success = (err == nil)
+		}
+		if success == test.isSuccess && (!success || norm == test.normOutput) {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
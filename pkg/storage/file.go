@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("file", func(root string) (Store, error) {
+		return fileStore{}, nil
+	})
+}
+
+// fileStore keeps objects on the local filesystem, keys are paths
+type fileStore struct{}
+
+func (fileStore) URL(key string) string {
+	return key
+}
+
+// Put writes to a temporary file next to key and renames it into place on
+// Close, so readers never see a partial object
+func (fileStore) Put(key string) (Writer, error) {
+	dir := filepath.Dir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(key)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: tmp, key: key}, nil
+}
+
+func (fileStore) Get(key string) (io.ReadCloser, error) {
+	ff, err := os.Open(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return ff, err
+}
+
+// List walks the directory holding prefix, or prefix itself when it names
+// a directory
+func (fileStore) List(prefix string) ([]Object, error) {
+	root := prefix
+	if info, err := os.Stat(prefix); err != nil || !info.IsDir() {
+		root = filepath.Dir(prefix)
+	}
+
+	objects := []Object{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasPrefix(path, filepath.Clean(prefix)) {
+			objects = append(objects, Object{Key: path, Size: info.Size(), LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return objects, nil
+	}
+	return objects, err
+}
+
+func (fileStore) Delete(key string) error {
+	err := os.Remove(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return err
+}
+
+func (fileStore) Stat(key string) (*Object, error) {
+	info, err := os.Stat(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Object{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// GetETag tags objects with the SHA-256 of their contents
+func (fileStore) GetETag(key string) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fileETag(data), nil
+}
+
+// PutIf links a complete temporary file into place, which fails if another
+// writer got there first. To replace an object, it is first renamed aside:
+// only one writer can do that, and it checks what it took before replacing.
+func (fs fileStore) PutIf(key string, data []byte, etag string) (string, error) {
+	if etag != "" {
+		taken := fmt.Sprintf("%s.%d-%d", key, os.Getpid(), time.Now().UnixNano())
+		if err := os.Rename(key, taken); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", fmt.Errorf("%s: %w", key, ErrPreconditionFailed)
+			}
+			return "", err
+		}
+		defer os.Remove(taken)
+		current, err := ioutil.ReadFile(taken)
+		if err != nil || fileETag(current) != etag {
+			// put back what was taken, unless it was already replaced
+			os.Link(taken, key)
+			if err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("%s: %w", key, ErrPreconditionFailed)
+		}
+	}
+
+	ww, err := fs.Put(key)
+	if err != nil {
+		return "", err
+	}
+	tmp := ww.(*fileWriter)
+	if _, err := tmp.File.Write(data); err != nil {
+		tmp.Abort()
+		return "", err
+	}
+	defer tmp.Abort()
+	if err := tmp.File.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Link(tmp.Name(), key); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("%s: %w", key, ErrPreconditionFailed)
+		}
+		return "", err
+	}
+	return fileETag(data), nil
+}
+
+func fileETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type fileWriter struct {
+	*os.File
+	key  string
+	done bool
+}
+
+func (w *fileWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Rename(w.Name(), w.key); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return nil
+}
+
+func (w *fileWriter) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.File.Close()
+	os.Remove(w.Name())
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// httpTimeout bounds a whole request, body included, so a server that
+	// stops responding fails the run instead of hanging it
+	httpTimeout = 15 * time.Minute
+	// httpResponseTimeout bounds the wait for the response once the request
+	// is sent
+	httpResponseTimeout = time.Minute
+)
+
+var httpClient = &http.Client{
+	Timeout: httpTimeout,
+	Transport: func() http.RoundTripper {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = httpResponseTimeout
+		return transport
+	}(),
+}
+
+func init() {
+	for _, scheme := range []string{"http", "https"} {
+		scheme := scheme
+		Register(scheme, func(host string) (Store, error) {
+			uu, err := url.Parse(scheme + "://" + host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid location %s: %w", Redact(scheme+"://"+host), errors.Unwrap(err))
+			}
+			return &httpStore{base: scheme + "://" + uu.Host, user: uu.User, client: httpClient}, nil
+		})
+	}
+}
+
+// httpStore keeps objects on a web server accepting PUT, GET, HEAD and
+// DELETE, such as a WebDAV share or a presigned URL. Credentials may be
+// given in the URL and are sent with basic authentication, they are kept
+// out of the locations of objects. Objects cannot be listed, and conditional
+// writes need a server that returns ETags.
+type httpStore struct {
+	base   string
+	user   *url.Userinfo
+	client *http.Client
+}
+
+// newRequest returns a request for the object at key, with the credentials
+// given with the location of the store
+func (h *httpStore) newRequest(method string, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, h.URL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if h.user != nil {
+		password, _ := h.user.Password()
+		req.SetBasicAuth(h.user.Username(), password)
+	}
+	return req, nil
+}
+
+// get sends a GET request for the object at key
+func (h *httpStore) get(key string) (*http.Response, error) {
+	req, err := h.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
+
+func (h *httpStore) URL(key string) string {
+	return h.base + key
+}
+
+// Put streams the object as the body of a single PUT request
+func (h *httpStore) Put(key string) (Writer, error) {
+	pr, pw := io.Pipe()
+	req, err := h.newRequest(http.MethodPut, key, pr)
+	if err != nil {
+		return nil, err
+	}
+	ww := &httpWriter{PipeWriter: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := h.client.Do(req)
+		if err == nil {
+			err = checkResponse(resp, h.URL(key))
+			resp.Body.Close()
+		}
+		// unblock writers if the server gave up early
+		pr.CloseWithError(err)
+		ww.done <- err
+	}()
+	return ww, nil
+}
+
+func (h *httpStore) Get(key string) (io.ReadCloser, error) {
+	resp, err := h.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, h.URL(key)); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange sends a Range request, servers that ignore it send the whole
+// object, of which only length bytes are read. Objects have no versions.
+func (h *httpStore) GetRange(key string, version string, length int64) (io.ReadCloser, error) {
+	if version != "" {
+		return nil, fmt.Errorf("http storage does not keep object versions")
+	}
+	req, err := h.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", length-1))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, h.URL(key)); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (h *httpStore) List(prefix string) ([]Object, error) {
+	return nil, fmt.Errorf("listing http storage: %w", ErrNotSupported)
+}
+
+// Listable is false, web servers have no common way to list objects
+func (h *httpStore) Listable() bool {
+	return false
+}
+
+func (h *httpStore) Delete(key string) error {
+	return h.do(http.MethodDelete, key, nil)
+}
+
+func (h *httpStore) Stat(key string) (*Object, error) {
+	obj := &Object{Key: key}
+	if err := h.do(http.MethodHead, key, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// GetETag needs the server to send an ETag with the object
+func (h *httpStore) GetETag(key string) ([]byte, string, error) {
+	resp, err := h.get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, h.URL(key)); err != nil {
+		return nil, "", err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return nil, "", fmt.Errorf("%s: server does not send ETags, conditional writes are not possible", h.URL(key))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, etag, err
+}
+
+// PutIf sends the If-None-Match or If-Match header, the tags are sent back
+// as the server gave them. Servers must return the ETag of what was written,
+// reading it back afterwards could return the tag of another writer.
+func (h *httpStore) PutIf(key string, data []byte, etag string) (string, error) {
+	req, err := h.newRequest(http.MethodPut, key, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if etag == "" {
+		req.Header.Set("If-None-Match", "*")
+	} else {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed || (etag != "" && resp.StatusCode == http.StatusNotFound) {
+		return "", fmt.Errorf("%s: %w", h.URL(key), ErrPreconditionFailed)
+	}
+	if err := checkResponse(resp, h.URL(key)); err != nil {
+		return "", err
+	}
+	tag := resp.Header.Get("ETag")
+	if tag == "" {
+		return "", fmt.Errorf("%s: server does not return the ETag of writes, conditional writes are not possible", h.URL(key))
+	}
+	return tag, nil
+}
+
+// do sends a request without a body, filling in the size, modification time
+// and ETag of obj when set
+func (h *httpStore) do(method string, key string, obj *Object) error {
+	req, err := h.newRequest(method, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, h.URL(key)); err != nil {
+		return err
+	}
+	if obj != nil {
+		obj.Size = resp.ContentLength
+		obj.ETag = strings.Trim(resp.Header.Get("ETag"), `"`)
+		if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			obj.LastModified = modified
+		}
+	}
+	return nil
+}
+
+func checkResponse(resp *http.Response, location string) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", location, ErrNotFound)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", location, resp.Status, body)
+	}
+	return nil
+}
+
+type httpWriter struct {
+	*io.PipeWriter
+	done chan error
+	err  error
+	shut bool
+}
+
+// Close finishes the request body and waits for the server to accept it
+func (w *httpWriter) Close() error {
+	if w.shut {
+		return w.err
+	}
+	w.shut = true
+	w.PipeWriter.Close()
+	w.err = <-w.done
+	return w.err
+}
+
+// Abort fails the request body so the server discards the upload
+func (w *httpWriter) Abort() {
+	if w.shut {
+		return
+	}
+	w.shut = true
+	w.PipeWriter.CloseWithError(errors.New("upload aborted"))
+	<-w.done
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dathan/go-vault-dump/pkg/aws"
+	"github.com/dathan/go-vault-dump/pkg/vault"
+)
+
+func init() {
+	Register("s3", func(bucket string) (Store, error) {
+		return s3Store{bucket: bucket}, nil
+	})
+}
+
+// s3Options are applied to every object written to S3, see SetS3Options
+var s3Options *aws.S3PutOptions
+
+// SetS3Options applies opts, such as server side encryption, storage class
+// or Object Lock, to every object written to S3
+func SetS3Options(opts *aws.S3PutOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	s3Options = opts
+	return nil
+}
+
+// s3Store keeps objects in one S3 bucket
+type s3Store struct {
+	bucket string
+}
+
+func (s s3Store) URL(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, vault.EnsureNoLeadingSlash(key))
+}
+
+func (s s3Store) Put(key string) (Writer, error) {
+	ww, err := aws.S3WriterWithOptions(s.URL(key), s3Options)
+	if err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (s s3Store) Get(key string) (io.ReadCloser, error) {
+	body, err := aws.S3Reader(s.URL(key))
+	return body, s.wrap(key, err)
+}
+
+func (s s3Store) List(prefix string) ([]Object, error) {
+	results, err := aws.S3List(s.URL(prefix), "")
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]Object, len(results))
+	for ii, rr := range results {
+		objects[ii] = s3Object(rr)
+	}
+	return objects, nil
+}
+
+func (s s3Store) GetVersion(key string, version string) (io.ReadCloser, error) {
+	body, err := aws.S3ReaderVersion(s.URL(key), version)
+	return body, s.wrap(key, err)
+}
+
+func (s s3Store) GetRange(key string, version string, length int64) (io.ReadCloser, error) {
+	body, err := aws.S3ReaderRange(s.URL(key), version, length)
+	return body, s.wrap(key, err)
+}
+
+func (s s3Store) ListVersions(prefix string) ([]Object, error) {
+	results, err := aws.S3ListVersions(s.URL(prefix), "")
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]Object, len(results))
+	for ii, rr := range results {
+		objects[ii] = s3Object(rr)
+	}
+	return objects, nil
+}
+
+func (s s3Store) RestoreVersion(key string, version string) error {
+	return s.wrap(key, aws.S3RestoreVersion(s.URL(key), version, s3Options))
+}
+
+func (s s3Store) Delete(key string) error {
+	return s.wrap(key, aws.S3Delete(s.URL(key)))
+}
+
+func (s s3Store) Stat(key string) (*Object, error) {
+	rr, err := aws.S3Stat(s.URL(key))
+	if err != nil {
+		return nil, s.wrap(key, err)
+	}
+	obj := s3Object(rr)
+	return &obj, nil
+}
+
+func (s s3Store) GetETag(key string) ([]byte, string, error) {
+	data, etag, err := aws.S3GetETag(s.URL(key))
+	return data, etag, s.wrap(key, err)
+}
+
+// PutIf uses the If-None-Match and If-Match conditions of PutObject, the
+// options set with SetS3Options apply except Object Lock
+func (s s3Store) PutIf(key string, data []byte, etag string) (string, error) {
+	tag, err := aws.S3PutIf(s.URL(key), data, etag, s3Options)
+	if err != nil && (aws.IsS3PreconditionFailed(err) || (etag != "" && aws.IsS3NotFound(err))) {
+		return "", fmt.Errorf("%s: %w", s.URL(key), ErrPreconditionFailed)
+	}
+	return tag, err
+}
+
+func s3Object(rr aws.S3ListResult) Object {
+	return Object{
+		Key:          rr.Key,
+		Size:         int64(rr.Size),
+		LastModified: rr.LastModified,
+		StorageClass: rr.StorageClass,
+		ETag:         rr.ETag,
+		VersionID:    rr.VersionID,
+		IsLatest:     rr.IsLatest,
+		DeleteMarker: rr.DeleteMarker,
+	}
+}
+
+// wrap turns S3 not found errors into ErrNotFound
+func (s s3Store) wrap(key string, err error) error {
+	if err != nil && aws.IsS3NotFound(err) {
+		return fmt.Errorf("%s: %w", s.URL(key), ErrNotFound)
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryServer stores objects in memory for PUT, GET, HEAD and DELETE
+func memoryServer() *httptest.Server {
+	var (
+		mu      sync.Mutex
+		objects = map[string][]byte{}
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if user, pass, _ := r.BasicAuth(); strings.HasPrefix(r.URL.Path, "/private/") && (user != "u" || pass != "p") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = data
+			if strings.HasPrefix(r.URL.Path, "/etag/") {
+				w.Header().Set("ETag", `"`+strconv.Itoa(len(data))+`"`)
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet, http.MethodHead:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			if _, ok := objects[r.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(objects, r.URL.Path)
+		}
+	}))
+}
+
+func TestSuiteStorage(tt *testing.T) {
+
+	dir, err := ioutil.TempDir("", "storage-test-*")
+	if err != nil {
+		tt.Fatalf("FAIL temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := memoryServer()
+	defer server.Close()
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Put to file", "Put", []string{filepath.Join(dir, "backups/test.txt"), "testing"}, "", true},
+			{"Put to file URL", "Put", []string{"file://" + filepath.Join(dir, "backups/other.txt"), "other"}, "", true},
+			{"Get from file", "Get", []string{filepath.Join(dir, "backups/test.txt")}, "testing", true},
+			{"Get from file URL", "Get", []string{"file://" + filepath.Join(dir, "backups/other.txt")}, "other", true},
+			{"Head of file", "Head", []string{filepath.Join(dir, "backups/test.txt"), "4"}, "test", true},
+			{"Get missing file", "Get", []string{filepath.Join(dir, "missing.txt")}, "not found", false},
+			{"List file prefix", "List", []string{filepath.Join(dir, "backups/t")}, "test.txt", true},
+			{"List file directory", "List", []string{filepath.Join(dir, "backups")}, "other.txt,test.txt", true},
+			{"List missing directory", "List", []string{filepath.Join(dir, "missing/")}, "", true},
+			{"Stat file", "Stat", []string{filepath.Join(dir, "backups/test.txt")}, "7", true},
+			{"Abort file upload", "Abort", []string{filepath.Join(dir, "backups/aborted.txt")}, "", true},
+			{"Get aborted file", "Get", []string{filepath.Join(dir, "backups/aborted.txt")}, "not found", false},
+			{"Delete file", "Delete", []string{filepath.Join(dir, "backups/other.txt")}, "", true},
+			{"Delete missing file", "Delete", []string{filepath.Join(dir, "backups/other.txt")}, "not found", false},
+			{"Put to http", "Put", []string{server.URL + "/backups/test.txt", "testing"}, "", true},
+			{"Get from http", "Get", []string{server.URL + "/backups/test.txt"}, "testing", true},
+			{"Head of http object", "Head", []string{server.URL + "/backups/test.txt", "4"}, "test", true},
+			{"Stat http", "Stat", []string{server.URL + "/backups/test.txt"}, "7", true},
+			{"Delete from http", "Delete", []string{server.URL + "/backups/test.txt"}, "", true},
+			{"Get missing from http", "Get", []string{server.URL + "/backups/test.txt"}, "not found", false},
+			{"List http", "List", []string{server.URL + "/backups/"}, "", false},
+			{"Put to http without credentials", "Put", []string{server.URL + "/private/test.txt", "testing"}, "", false},
+			{"Put to http with credentials", "Put", []string{strings.Replace(server.URL, "://", "://u:p@", 1) + "/private/test.txt", "testing"}, "", true},
+			{"Get from http with credentials", "Get", []string{strings.Replace(server.URL, "://", "://u:p@", 1) + "/private/test.txt"}, "testing", true},
+			{"Get from http without credentials given again", "Get", []string{server.URL + "/private/test.txt"}, "", false},
+			{"Conditional put to http with ETag", "PutIf", []string{server.URL + "/etag/test.txt", "testing"}, `"7"`, true},
+			{"Conditional put to http without ETag", "PutIf", []string{server.URL + "/backups/lock", "testing"}, "", false},
+			{"Listable http", "Listable", []string{server.URL + "/backups/"}, "", false},
+			{"Listable file", "Listable", []string{filepath.Join(dir, "backups")}, "", true},
+			{"Credentials for the same server", "WithCredentials", []string{"https://host/a", "https://u:p@host/latest"}, "https://u:p@host/a", true},
+			{"Credentials for another server", "WithCredentials", []string{"https://other/a", "https://u:p@host/latest"}, "https://other/a", true},
+			{"Credentials for a server with a longer name", "WithCredentials", []string{"https://hostname/a", "https://u:p@host/latest"}, "https://hostname/a", true},
+			{"Credentials given with the location", "WithCredentials", []string{"https://v:q@host/a", "https://u:p@host/latest"}, "https://v:q@host/a", true},
+			{"Extension of presigned URL", "WithExt", []string{"https://host/a.aes?X-Amz-Signature=1", ".manifest.json"}, "https://host/a.aes.manifest.json?X-Amz-Signature=1", true},
+			{"Extension of S3 location", "WithExt", []string{"s3://bucket/a?.aes", ".manifest.json"}, "s3://bucket/a?.aes.manifest.json", true},
+			{"Location of http object with credentials", "URL", []string{strings.Replace(server.URL, "://", "://u:p@", 1) + "/private/test.txt"}, server.URL + "/private/test.txt", true},
+			{"Redact http location", "Redact", []string{"https://u:p@host/a@b"}, "https://host/a@b", true},
+			{"Redact location without credentials", "Redact", []string{"s3://bucket/a@b"}, "s3://bucket/a@b", true},
+			{"Open unknown scheme", "Get", []string{"ftp://host/test.txt"}, "", false},
+		}
+	)
+
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "Put":
+			err = WriteAll(test.inputs[0], []byte(test.inputs[1]))
+		case "Get":
+			var data []byte
+			data, err = ReadAll(test.inputs[0])
+			norm = string(data)
+		case "Head":
+			var body io.ReadCloser
+			length, _ := strconv.ParseInt(test.inputs[1], 10, 64)
+			body, err = GetHead(test.inputs[0], "", length)
+			if err == nil {
+				data, _ := ioutil.ReadAll(body)
+				body.Close()
+				norm = string(data)
+			}
+		case "List":
+			var objects []Object
+			objects, err = List(test.inputs[0])
+			names := []string{}
+			for _, obj := range objects {
+				names = append(names, filepath.Base(obj.Key))
+			}
+			sort.Strings(names)
+			norm = strings.Join(names, ",")
+		case "Stat":
+			var obj *Object
+			obj, err = Stat(test.inputs[0])
+			if err == nil {
+				norm = strconv.FormatInt(obj.Size, 10)
+			}
+		case "Abort":
+			var ww Writer
+			ww, err = Put(test.inputs[0])
+			if err == nil {
+				ww.Write([]byte("partial"))
+				ww.Abort()
+			}
+		case "Delete":
+			err = Delete(test.inputs[0])
+		case "URL":
+			var store Store
+			var key string
+			store, key, err = Open(test.inputs[0])
+			if err == nil {
+				norm = store.URL(key)
+			}
+		case "Redact":
+			err = nil
+			norm = Redact(test.inputs[0])
+		case "WithCredentials":
+			err = nil
+			norm = WithCredentials(test.inputs[0], test.inputs[1])
+		case "WithExt":
+			err = nil
+			norm = WithExt(test.inputs[0], test.inputs[1])
+		case "Listable":
+			err = Listable(test.inputs[0])
+		case "PutIf":
+			var store Store
+			var key string
+			store, key, err = Open(test.inputs[0])
+			if err == nil {
+				norm, err = store.(Conditional).PutIf(key, []byte(test.inputs[1]), "")
+			}
+		}
+		success = (err == nil)
+		if !success && test.normOutput == "not found" && errors.Is(err, ErrNotFound) {
+			norm = "not found"
+		}
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
+
+// versionsAt returns versions named v<minute> written at those minutes past
+// midnight, newest first; negative minutes are delete markers
+func versionsAt(minutes ...int) []Object {
+	versions := []Object{}
+	for _, mm := range minutes {
+		obj := Object{VersionID: "v" + strconv.Itoa(mm), LastModified: time.Date(2024, 1, 1, 0, mm, 0, 0, time.UTC)}
+		if mm < 0 {
+			obj.VersionID = "d" + strconv.Itoa(-mm)
+			obj.LastModified = time.Date(2024, 1, 1, 0, -mm, 0, 0, time.UTC)
+			obj.DeleteMarker = true
+		}
+		versions = append(versions, obj)
+	}
+	return versions
+}
+
+func TestSuiteCompanionVersion(tt *testing.T) {
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			versions    []Object
+			companions  []Object
+			inputs      string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Newest version", versionsAt(30, 20, 10), versionsAt(31, 21, 11), "v30", "v31", true},
+			{"Middle version", versionsAt(30, 20, 10), versionsAt(31, 21, 11), "v20", "v21", true},
+			{"Oldest version", versionsAt(30, 20, 10), versionsAt(31, 21, 11), "v10", "v11", true},
+			{"Companion missing", versionsAt(30, 20, 10), versionsAt(31, 11), "v20", "", true},
+			{"Companion rewritten", versionsAt(30, 20), versionsAt(31, 25, 21), "v20", "v21", true},
+			{"Across delete marker", versionsAt(30, -25, 20), versionsAt(31, 21), "v20", "v21", true},
+			{"Companion deleted", versionsAt(30, 20), versionsAt(31, -21), "v20", "", true},
+			{"Unknown version", versionsAt(30, 20), versionsAt(31, 21), "v99", "", false},
+		}
+	)
+
+	for _, test := range tests {
+		match, err := CompanionVersion(test.versions, test.companions, test.inputs)
+		success = (err == nil)
+		norm = ""
+		if match != nil {
+			norm = match.VersionID
+		}
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t (%v)", test.description, test.isSuccess, success, err)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
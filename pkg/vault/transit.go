@@ -0,0 +1,136 @@
+package vault
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+const (
+	DefaultTransitMount = "transit"
+)
+
+// TransitProvider wraps bundle data keys with a Vault Transit key, for
+// environments without AWS
+type TransitProvider struct {
+	Client *vaultapi.Client
+	Mount  string
+	Key    string
+}
+
+// NewTransitProvider returns a provider using the transit key named key
+// mounted at mount, DefaultTransitMount when empty
+func NewTransitProvider(vc *Config, mount string, key string) *TransitProvider {
+	if mount == "" {
+		mount = DefaultTransitMount
+	}
+	return &TransitProvider{
+		Client: vc.Client,
+		Mount:  EnsureNoTrailingSlash(EnsureNoLeadingSlash(mount)),
+		Key:    key,
+	}
+}
+
+func (t *TransitProvider) Name() string {
+	return bundle.Transit
+}
+
+// GenerateKey asks transit for a new data key with datakey/wrapped, and
+// decrypts it straight away so the bundle is known to be recoverable
+func (t *TransitProvider) GenerateKey(size int) ([]byte, *bundle.Stanza, error) {
+	if t.Key == "" {
+		return nil, nil, errors.New("transit key must be specified")
+	}
+	secret, err := t.Client.Logical().Write(fmt.Sprintf("%s/datakey/wrapped/%s", t.Mount, t.Key), map[string]interface{}{
+		"bits": size * 8,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := transitField(secret, "ciphertext")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stanza := t.stanza(ciphertext)
+	key, err := t.Unwrap(stanza)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, stanza, nil
+}
+
+// Wrap encrypts a data key generated elsewhere with transit/encrypt
+func (t *TransitProvider) Wrap(key []byte) (*bundle.Stanza, error) {
+	if t.Key == "" {
+		return nil, errors.New("transit key must be specified")
+	}
+	secret, err := t.Client.Logical().Write(fmt.Sprintf("%s/encrypt/%s", t.Mount, t.Key), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := transitField(secret, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+	return t.stanza(ciphertext), nil
+}
+
+// Unwrap decrypts the data key with transit/decrypt. The stanza comes from
+// the bundle, so it is only used with the mount of the provider and, when
+// the provider has one, its key: a crafted bundle must not make the token
+// write to another path.
+func (t *TransitProvider) Unwrap(stanza *bundle.Stanza) ([]byte, error) {
+	if mount := stanza.Params["mount"]; mount != "" && EnsureNoTrailingSlash(EnsureNoLeadingSlash(mount)) != t.Mount {
+		return nil, fmt.Errorf("bundle is wrapped with the transit mount %q, not %q", mount, t.Mount)
+	}
+	key := stanza.KeyID
+	if !validTransitKey(key) {
+		return nil, fmt.Errorf("invalid transit key name %q in bundle", key)
+	}
+	if t.Key != "" && key != t.Key {
+		return nil, fmt.Errorf("bundle is wrapped with the transit key %q, not %q", key, t.Key)
+	}
+	secret, err := t.Client.Logical().Write(fmt.Sprintf("%s/decrypt/%s", t.Mount, key), map[string]interface{}{
+		"ciphertext": string(stanza.Wrapped),
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := transitField(secret, "plaintext")
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(plaintext)
+}
+
+// validTransitKey reports whether name can only name a key of the mount
+func validTransitKey(name string) bool {
+	return name != "" && !strings.Contains(name, "/") && !strings.Contains(name, "..")
+}
+
+func (t *TransitProvider) stanza(ciphertext string) *bundle.Stanza {
+	return &bundle.Stanza{
+		Provider: bundle.Transit,
+		KeyID:    t.Key,
+		Params:   map[string]string{"mount": t.Mount},
+		Wrapped:  []byte(ciphertext),
+	}
+}
+
+func transitField(secret *vaultapi.Secret, field string) (string, error) {
+	if secret == nil || secret.Data == nil {
+		return "", errors.New("empty response from transit")
+	}
+	value, ok := secret.Data[field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("transit response has no %s", field)
+	}
+	return value, nil
+}
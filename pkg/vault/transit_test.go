@@ -0,0 +1,102 @@
+package vault
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dathan/go-vault-dump/pkg/bundle"
+)
+
+func TestSuiteTransit(tt *testing.T) {
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Encrypt and Decrypt string", "EncryptDecrypt", []string{"This is a test!", "vault-dump"}, "This is a test!", true},
+			{"Encrypt and Decrypt empty string", "EncryptDecrypt", []string{"", "vault-dump"}, "", true},
+			{"Encrypt with missing key", "EncryptDecrypt", []string{"This is a test!", "missing"}, "", false},
+			{"Encrypt without key", "EncryptDecrypt", []string{"This is a test!", ""}, "", false},
+			{"Unwrap from another mount", "Unwrap", []string{"secret/data/x", "y", ""}, "mount", false},
+			{"Unwrap key with slash", "Unwrap", []string{"transit", "../../secret/data/x", ""}, "key name", false},
+			{"Unwrap key with dots", "Unwrap", []string{"transit", "..", ""}, "key name", false},
+			{"Unwrap other than configured key", "Unwrap", []string{"transit", "other", "vault-dump"}, "configured key", false},
+			{"Unwrap configured key", "Unwrap", []string{"transit", "vault-dump", "vault-dump"}, "requested", false},
+		}
+	)
+	vc, _ := NewClient(&Config{
+		Address: os.Getenv("VAULT_ADDR"),
+		Token:   os.Getenv("VAULT_TOKEN"),
+	})
+
+	// unwrap checks the stanza before any request, which this server
+	// counts and refuses
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	counted, _ := NewClient(&Config{Address: server.URL, Token: "test"})
+	for _, test := range tests {
+		norm = ""
+		switch test.action {
+		case "EncryptDecrypt":
+			tp := NewTransitProvider(vc, "", test.inputs[1])
+			var buf bytes.Buffer
+			ww, err := bundle.NewWriter(&buf, &bundle.Options{Recipients: []bundle.Provider{tp}})
+			success = (err == nil)
+			if success {
+				ww.Write([]byte(test.inputs[0]))
+				ww.Close()
+				resolve := func(*bundle.Stanza) (bundle.Provider, error) { return NewTransitProvider(vc, "", ""), nil }
+				rr, err := bundle.NewReader(&buf, resolve)
+				success = (err == nil)
+				if success {
+					out, _ := ioutil.ReadAll(rr)
+					norm = string(out)
+				}
+			}
+		case "Unwrap":
+			atomic.StoreInt32(&requests, 0)
+			tp := NewTransitProvider(counted, "", test.inputs[2])
+			_, err := tp.Unwrap(&bundle.Stanza{
+				Provider: bundle.Transit,
+				KeyID:    test.inputs[1],
+				Params:   map[string]string{"mount": test.inputs[0]},
+				Wrapped:  []byte("vault:v1:AAAA"),
+			})
+			success = (err == nil)
+			switch {
+			case atomic.LoadInt32(&requests) > 0:
+				norm = "requested"
+			case err == nil:
+			case strings.Contains(err.Error(), "transit mount"):
+				norm = "mount"
+			case strings.Contains(err.Error(), "invalid transit key name"):
+				norm = "key name"
+			case strings.Contains(err.Error(), "wrapped with the transit key"):
+				norm = "configured key"
+			}
+		}
+
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package verify checks that a decrypted dump can be restored, and that a
+// restore reproduced it. Problems name the vault path but never the secret
+// values, so reports can be kept in CI logs.
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/dathan/go-vault-dump/pkg/vault"
+)
+
+// Validate checks that every entry of a dump has the shape import expects:
+// secrets are maps and policies have a name and non-empty rules
+func Validate(data map[string]interface{}) []string {
+	problems := []string{}
+	for path, value := range data {
+		secret, ok := value.(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: entry is %T, not a map", path, value))
+			continue
+		}
+		if vault.IsPolicy(path) {
+			name, _ := secret["name"].(string)
+			rules, _ := secret["rules"].(string)
+			if name == "" || rules == "" {
+				problems = append(problems, fmt.Sprintf("%s: policy has no name or rules", path))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Compare returns the paths of expected that are missing from actual or
+// hold different values, paths only present in actual are not reported
+func Compare(expected map[string]interface{}, actual map[string]interface{}) []string {
+	problems := []string{}
+	for path, value := range expected {
+		got, ok := actual[path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing after restore", path))
+			continue
+		}
+		if !reflect.DeepEqual(normalize(value), normalize(got)) {
+			problems = append(problems, fmt.Sprintf("%s: differs after restore", path))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// normalize round trips a value through JSON so numbers and map types
+// decoded from a file and read from Vault compare equal
+func normalize(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return value
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package verify
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSuiteVerify(tt *testing.T) {
+
+	dump := func(doc string) map[string]interface{} {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(doc), &data); err != nil {
+			tt.Fatalf("FAIL parse: %s", err)
+		}
+		return data
+	}
+	bundle := `{"secret/data/app":{"user":"admin","port":5432},"/sys/policy/app":{"name":"app","rules":"path \"secret/*\" {}"}}`
+
+	var (
+		norm    string
+		success bool
+		tests   = []struct {
+			description string
+			action      string
+			inputs      []string
+			normOutput  string
+			isSuccess   bool
+		}{
+			{"Validate dump", "Validate", []string{bundle}, "", true},
+			{"Validate entry that is not a map", "Validate", []string{`{"secret/data/app":"admin"}`}, "secret/data/app: entry is string, not a map", false},
+			{"Validate policy without rules", "Validate", []string{`{"/sys/policy/app":{"name":"app"}}`}, "/sys/policy/app: policy has no name or rules", false},
+			{"Compare identical restore", "Compare", []string{bundle, bundle}, "", true},
+			{"Compare restore with extra paths", "Compare", []string{bundle, `{"secret/data/app":{"user":"admin","port":5432.0},"/sys/policy/app":{"name":"app","rules":"path \"secret/*\" {}"},"secret/data/other":{}}`}, "", true},
+			{"Compare restore with changed value", "Compare", []string{bundle, `{"secret/data/app":{"user":"root","port":5432},"/sys/policy/app":{"name":"app","rules":"path \"secret/*\" {}"}}`}, "secret/data/app: differs after restore", false},
+			{"Compare restore with missing path", "Compare", []string{bundle, `{"secret/data/app":{"user":"admin","port":5432}}`}, "/sys/policy/app: missing after restore", false},
+		}
+	)
+
+	for _, test := range tests {
+		var problems []string
+		switch test.action {
+		case "Validate":
+			problems = Validate(dump(test.inputs[0]))
+		case "Compare":
+			problems = Compare(dump(test.inputs[0]), dump(test.inputs[1]))
+		}
+		success = len(problems) == 0
+		norm = strings.Join(problems, "\n")
+		if success == test.isSuccess && norm == test.normOutput {
+			tt.Logf("PASS %s", test.description)
+		} else if success != test.isSuccess {
+			tt.Errorf("FAIL %s: expected %t got %t", test.description, test.isSuccess, success)
+		} else {
+			tt.Errorf("FAIL %s: expected '%s' got '%s'", test.description, test.normOutput, norm)
+		}
+	}
+}
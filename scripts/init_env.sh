@@ -5,6 +5,9 @@ sleep 10
 docker-compose exec vault vault secrets disable secret
 docker-compose exec vault vault secrets enable -version=1 -path=/secret kv
 docker-compose exec vault vault secrets enable database
+docker-compose exec vault vault secrets enable transit
+docker-compose exec vault vault write -f transit/keys/vault-dump
 docker-compose exec vault vault kv put /secret/foo/bar baz=bat
 docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3 mb s3://test
+docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3api create-bucket --bucket test-lock --object-lock-enabled-for-bucket
 echo done
@@ -6,5 +6,11 @@ export VAULT_TOKEN=Test_123
 export KMS_KEY_ARN=$(docker-compose exec localstack aws --endpoint-url=http://localhost:4566 --region=us-east-1 kms create-key | jq .KeyMetadata.Arn -rj)
 docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3 rm s3://test --recursive
 docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3 mb s3://test
+# object lock and version tests need a bucket created with object lock, which
+# also turns on versioning; its versions cannot be emptied like s3://test
+docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3api head-bucket --bucket test-lock ||
+	docker-compose exec localstack aws --endpoint-url=http://localhost:4566 s3api create-bucket --bucket test-lock --object-lock-enabled-for-bucket
 docker-compose exec vault vault kv put /secret/foo/bar baz=bat
-go test ./pkg/aws ./pkg/print ./pkg/vault -coverprofile=coverage.out
+docker-compose exec vault vault secrets enable transit || true
+docker-compose exec vault vault write -f transit/keys/vault-dump
+go test ./... -coverprofile=coverage.out